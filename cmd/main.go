@@ -1,5 +1,52 @@
 package main
 
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
 func main() {
+	dsn := flag.String("mysql", os.Getenv("MYSQL_DSN"), "MySQL data source name")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-mysql dsn] dead-letters list [projection] | discard <id>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(*dsn, flag.Args()...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dsn string, args ...string) error {
+	if len(args) == 0 || args[0] != "dead-letters" {
+		flag.Usage()
+		return fmt.Errorf("unknown command")
+	}
+
+	// retry delivers events to projections, so it is run by service, where
+	// they are subscribed.
+	if len(args) > 1 && args[1] == "retry" {
+		return fmt.Errorf("dead-letters retry is not available, projections are subscribed by service")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	// events are not consumed here, dead letters are only listed or discarded
+	ps := es.NewMemPubSub()
+	defer ps.Close()
 
+	s := cqrs.NewSubscriber(ps, cqrs.DefaultSerializer).DeadLetters(es.NewMySQLDeadLetters(db))
+	return s.DeadLetterCommand(os.Stdout, args[1:]...)
 }
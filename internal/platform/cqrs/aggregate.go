@@ -0,0 +1,110 @@
+package cqrs
+
+import (
+	"fmt"
+	"time"
+)
+
+// Aggregate keeps bookkeeping of AggregateRoot, it is embedded in domain type,
+// which initiates it and handles events applied to it:
+//
+//	type Order struct {
+//		cqrs.Aggregate
+//		paid bool
+//	}
+//
+//	func NewOrder(id string) *Order {
+//		o := &Order{}
+//		o.Init(id, "Order", cqrs.EventHandlerFunc(o.apply))
+//		return o
+//	}
+//
+//	func (o *Order) Pay() error {
+//		if o.paid {
+//			return ErrPaid
+//		}
+//		return o.Raise(Paid{})
+//	}
+//
+// Version of Aggregate is version of its stored events, it grows when
+// uncommitted events are cleared by Repository.Store. Raised events are named
+// by Registry given by Repository.Load or Executor, like Repository.Store names
+// them.
+type Aggregate struct {
+	id       string
+	name     string
+	version  uint
+	events   []interface{}
+	apply    EventHandler
+	registry Registry
+}
+
+// Init sets identity of aggregate and handler applying its loaded and raised
+// events.
+func (a *Aggregate) Init(id, name string, apply EventHandler) {
+	a.id, a.name, a.apply = id, name, apply
+}
+
+// EventNames sets Registry naming raised events, without it they are named
+// like by empty Registry.
+func (a *Aggregate) EventNames(r Registry) { a.registry = r }
+
+func (a *Aggregate) Details() (id, name string, version uint) {
+	return a.id, a.name, a.version
+}
+
+// Uncommitted returns raised events, clear removes them and moves version of
+// aggregate forward. Repository.Store clears them after they are stored.
+func (a *Aggregate) Uncommitted(clear bool) []interface{} {
+	ee := a.events
+	if clear {
+		a.version += uint(len(a.events))
+		a.events = nil
+	}
+
+	return ee
+}
+
+// Handle applies loaded event.
+func (a *Aggregate) Handle(e Event) error {
+	if err := a.handle(e); err != nil {
+		return err
+	}
+
+	a.version = e.Version
+	return nil
+}
+
+// Raise applies new event and records it as uncommitted, when event is not
+// registered or apply fails, event is not recorded.
+func (a *Aggregate) Raise(event interface{}) error {
+	n, err := a.registry.Name(event)
+	if err != nil {
+		return fmt.Errorf("%s.%s %s", a.id, a.name, err)
+	}
+
+	e := Event{
+		Data:      event,
+		Type:      n,
+		Version:   a.version + uint(len(a.events)) + 1,
+		CreatedAt: time.Now(),
+	}
+
+	e.Aggregate.ID = a.id
+	e.Aggregate.Type = a.name
+
+	if err := a.handle(e); err != nil {
+		return err
+	}
+
+	a.events = append(a.events, event)
+	return nil
+}
+
+func (a *Aggregate) handle(e Event) error {
+	if a.apply == nil {
+		return fmt.Errorf("%s.%s aggregate not initiated", a.id, a.name)
+	}
+
+	return a.apply.Handle(e)
+}
@@ -0,0 +1,189 @@
+package cqrs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/sokool/gokit/log"
+)
+
+var (
+	ErrValidation   = errors.New("validation failed")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// CommandMiddleware wraps CommandHandler with behaviour common to all
+// commands.
+type CommandMiddleware func(CommandHandler) CommandHandler
+
+// CommandFactory creates pointer to zero value of command of given name,
+// ready to be decoded.
+type CommandFactory func(name string) (interface{}, error)
+
+// CommandBus dispatches commands to handlers registered for their types,
+// through middleware chain. Command is a value or pointer of registered type,
+// its name is resolved same way as event names in Registry.
+type CommandBus struct {
+	mu         sync.RWMutex
+	commands   Registry
+	handlers   map[string]CommandHandler
+	middleware []CommandMiddleware
+}
+
+func NewCommandBus() *CommandBus {
+	return &CommandBus{
+		commands: Registry{},
+		handlers: make(map[string]CommandHandler),
+	}
+}
+
+// Register handler of command type, one type has one handler.
+func (b *CommandBus) Register(command interface{}, h CommandHandler) error {
+	n := name(command)
+	if n == "" {
+		return fmt.Errorf("command name not resolved")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[n]; ok {
+		return fmt.Errorf("command %q already registered", n)
+	}
+
+	if t := reflect.TypeOf(command); t.Kind() == reflect.Ptr {
+		command = reflect.New(t.Elem()).Elem().Interface()
+	}
+
+	if err := b.commands.Register(command, n); err != nil {
+		return err
+	}
+
+	b.handlers[n] = h
+	return nil
+}
+
+// Use appends middleware, first one is outermost.
+func (b *CommandBus) Use(mm ...CommandMiddleware) *CommandBus {
+	b.mu.Lock()
+	b.middleware = append(b.middleware, mm...)
+	b.mu.Unlock()
+
+	return b
+}
+
+// Command creates pointer to zero value of registered command, ready to be
+// decoded.
+func (b *CommandBus) Command(name string) (interface{}, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	v, err := b.commands.Type(name)
+	if err != nil {
+		return nil, fmt.Errorf("command %q not registered", name)
+	}
+
+	return v.Interface(), nil
+}
+
+func (b *CommandBus) Handle(id string, command interface{}, m Meta) Response {
+	n := name(command)
+
+	b.mu.RLock()
+	h, ok := b.handlers[n]
+	mm := b.middleware
+	b.mu.RUnlock()
+
+	if !ok {
+		return Response{ID: id, Name: n, Error: fmt.Errorf("command %q not registered", n)}
+	}
+
+	for i := len(mm) - 1; i >= 0; i-- {
+		h = mm[i](h)
+	}
+
+	return h.Handle(id, command, m)
+}
+
+// Validation rejects command implementing Validator when it is not valid,
+// error wraps ErrValidation. Command sent as value is validated also when
+// Validate has pointer receiver.
+func Validation() CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(id string, c interface{}, m Meta) Response {
+			if v, ok := validator(c); ok {
+				if err := v.Validate(); err != nil {
+					return Response{ID: id, Name: name(c), Error: fmt.Errorf("%w: %s", ErrValidation, err)}
+				}
+			}
+
+			return next.Handle(id, c, m)
+		})
+	}
+}
+
+// validator of value, or of pointer to its copy, when Validate has pointer
+// receiver.
+func validator(v interface{}) (Validator, bool) {
+	if x, ok := v.(Validator); ok {
+		return x, true
+	}
+
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() == reflect.Ptr || !reflect.PtrTo(t).Implements(reflect.TypeOf((*Validator)(nil)).Elem()) {
+		return nil, false
+	}
+
+	p := reflect.New(t)
+	p.Elem().Set(reflect.ValueOf(v))
+
+	return p.Interface().(Validator), true
+}
+
+// Authorization rejects command when f returns error, error wraps
+// ErrUnauthorized.
+func Authorization(f func(id string, command interface{}, m Meta) error) CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(id string, c interface{}, m Meta) Response {
+			if err := f(id, c, m); err != nil {
+				return Response{ID: id, Name: name(c), Error: fmt.Errorf("%w: %s", ErrUnauthorized, err)}
+			}
+
+			return next.Handle(id, c, m)
+		})
+	}
+}
+
+// Logging writes handled command, its result and duration.
+func Logging() CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(id string, c interface{}, m Meta) Response {
+			t := time.Now()
+			r := next.Handle(id, c, m)
+			if r.Error != nil {
+				log.Error("cqrs.bus", fmt.Errorf("%s.%s command failed in %s: %w", id, name(c), time.Since(t), r.Error))
+				return r
+			}
+
+			log.Debug("cqrs.bus", "%s.%s command handled in %s, version %d", id, name(c), time.Since(t), r.Version)
+			return r
+		})
+	}
+}
+
+// Metrics calls f with command name, duration and error of every handled
+// command.
+func Metrics(f func(command string, d time.Duration, err error)) CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(id string, c interface{}, m Meta) Response {
+			t := time.Now()
+			r := next.Handle(id, c, m)
+			f(name(c), time.Since(t), r.Error)
+
+			return r
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package cqrs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+)
+
+type Rename struct{ Name string }
+
+func (r *Rename) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name required")
+	}
+
+	return nil
+}
+
+func TestCommandBusValidation(t *testing.T) {
+	b := cqrs.NewCommandBus().Use(cqrs.Validation())
+	h := cqrs.CommandHandlerFunc(func(id string, c interface{}, m cqrs.Meta) cqrs.Response {
+		return cqrs.Response{ID: id}
+	})
+
+	if err := b.Register(Rename{}, h); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc    string
+		command interface{}
+		err     error
+	}{
+		{"value", Rename{}, cqrs.ErrValidation},
+		{"pointer", &Rename{}, cqrs.ErrValidation},
+		{"valid value", Rename{Name: "Dune"}, nil},
+		{"valid pointer", &Rename{Name: "Dune"}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			if r := b.Handle("dune", c.command, nil); !errors.Is(r.Error, c.err) {
+				t.Fatalf("%v expected, got %v", c.err, r.Error)
+			}
+		})
+	}
+}
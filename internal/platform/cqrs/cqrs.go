@@ -46,6 +46,7 @@ type Response struct {
 	Name    string      `json:"name"`
 	Error   error       `json:"errors"`
 	Version uint        `json:"version"`
+	Events  []string    `json:"events"`
 	Data    interface{} `json:"response"`
 }
 
@@ -60,6 +61,7 @@ func (r Response) MarshalJSON() ([]byte, error) {
 		"name":    r.Name,
 		"errors":  e,
 		"version": r.Version,
+		"events":  r.Events,
 		"data":    r.Data,
 	})
 }
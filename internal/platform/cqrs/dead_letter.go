@@ -0,0 +1,86 @@
+package cqrs
+
+import (
+	"fmt"
+	"io"
+)
+
+// DeadLetterCommand is a command line interface over Subscriber dead letters,
+// meant to be wired into service binary, which knows all its projections.
+//
+//	list [projection]       prints parked events
+//	retry <id>...           delivers parked events again
+//	retry all [projection]  delivers all parked events again
+//	discard <id>...         removes parked events
+func (p *Subscriber) DeadLetterCommand(w io.Writer, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: list [projection] | retry <id>... | retry all [projection] | discard <id>...")
+	}
+
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "list":
+		var projection string
+		if len(args) > 0 {
+			projection = args[0]
+		}
+
+		dd, err := p.Parked(projection)
+		if err != nil {
+			return err
+		}
+
+		for i := range dd {
+			fmt.Fprintf(w, "%s\n", dd[i])
+		}
+
+		fmt.Fprintf(w, "%d parked\n", len(dd))
+		return nil
+
+	case "retry":
+		if len(args) > 0 && args[0] == "all" {
+			var projection string
+			if len(args) > 1 {
+				projection = args[1]
+			}
+
+			dd, err := p.Parked(projection)
+			if err != nil {
+				return err
+			}
+
+			args = nil
+			for i := range dd {
+				args = append(args, dd[i].ID)
+			}
+		}
+
+		var failed int
+		for _, id := range args {
+			if err := p.Retry(id); err != nil {
+				failed++
+				fmt.Fprintf(w, "%s failed: %s\n", id, err)
+				continue
+			}
+			fmt.Fprintf(w, "%s retried\n", id)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d events failed again", failed, len(args))
+		}
+
+		return nil
+
+	case "discard":
+		for _, id := range args {
+			if err := p.Discard(id); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s discarded\n", id)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unknown %s dead letter command", cmd)
+	}
+}
@@ -0,0 +1,130 @@
+package cqrs_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+type books struct {
+	fail    error
+	handled []cqrs.Event
+}
+
+func (b *books) Type() string { return "books" }
+
+func (b *books) Subscribe(ss cqrs.Subscriptions) error { return ss.Assign("Book", Created{}) }
+
+func (b *books) Handle(e cqrs.Event) error {
+	if b.fail != nil {
+		return b.fail
+	}
+
+	b.handled = append(b.handled, e)
+	return nil
+}
+
+func TestDeadLetters(t *testing.T) {
+	ps := es.NewMemPubSub(es.MemSynchronous())
+	defer ps.Close()
+
+	p := &books{fail: fmt.Errorf("database is down")}
+	s := cqrs.NewSubscriber(ps, cqrs.DefaultSerializer).DeadLetters(es.NewMemDeadLetters())
+	if err := s.Subscribe(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Subscribe(&books{}); err == nil {
+		t.Fatal("error expected, when projection of the same name subscribes")
+	}
+
+	publish := func(v uint) {
+		err := ps.Publish(es.AggregateEvents{
+			Aggregate: es.Aggregate{ID: "dune", Type: "Book"},
+			Events:    []es.Event{{Type: "Created", Version: v, Data: []byte(`{"Name":"Dune"}`)}},
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	command := func(args ...string) string {
+		var w bytes.Buffer
+		if err := s.DeadLetterCommand(&w, args...); err != nil {
+			t.Fatalf("%s: %s", strings.Join(args, " "), err)
+		}
+
+		return w.String()
+	}
+
+	publish(1)
+	if o := command("list", "books"); !strings.Contains(o, "database is down") || !strings.HasSuffix(o, "1 parked\n") {
+		t.Fatalf("parked event expected, got %q", o)
+	}
+
+	p.fail = nil
+	if o := command("retry", "all"); !strings.Contains(o, "books.Book.dune.Created.1 retried") {
+		t.Fatalf("retried event expected, got %q", o)
+	}
+
+	if len(p.handled) != 1 || p.handled[0].Data.(Created).Name != "Dune" {
+		t.Fatalf("one Created event handled expected, got %v", p.handled)
+	}
+
+	p.fail = fmt.Errorf("database is down")
+	publish(2)
+	if o := command("discard", "books.Book.dune.Created.2"); o != "books.Book.dune.Created.2 discarded\n" {
+		t.Fatalf("discarded event expected, got %q", o)
+	}
+
+	if o := command("list"); o != "0 parked\n" {
+		t.Fatalf("no parked events expected, got %q", o)
+	}
+
+	if len(p.handled) != 1 {
+		t.Fatalf("discarded event handled")
+	}
+}
+
+type brokenDeadLetters struct {
+	es.DeadLetters
+	fail error
+}
+
+func (b *brokenDeadLetters) Park(d es.DeadLetter) error {
+	if b.fail != nil {
+		return b.fail
+	}
+
+	return b.DeadLetters.Park(d)
+}
+
+func TestDeadLettersRetryParkFailure(t *testing.T) {
+	ps := es.NewMemPubSub(es.MemSynchronous())
+	defer ps.Close()
+
+	d := &brokenDeadLetters{DeadLetters: es.NewMemDeadLetters()}
+	s := cqrs.NewSubscriber(ps, cqrs.DefaultSerializer).DeadLetters(d)
+	if err := s.Subscribe(&books{fail: fmt.Errorf("database is down")}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ps.Publish(es.AggregateEvents{
+		Aggregate: es.Aggregate{ID: "dune", Type: "Book"},
+		Events:    []es.Event{{Type: "Created", Version: 1, Data: []byte(`{"Name":"Dune"}`)}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.fail = fmt.Errorf("dead letters are down")
+	if err := s.Retry("books.Book.dune.Created.1"); err == nil || !strings.Contains(err.Error(), "dead letters are down") {
+		t.Fatalf("parking failure expected, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package cqrs
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sokool/gokit/log"
+)
+
+var (
+	eventType = reflect.TypeOf(Event{})
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Dispatcher is an EventHandler calling function registered for type of
+// Event.Data, instead of type switch. Functions are methods following
+// convention
+//
+//	func (o *Order) OnPaid(e Paid, m cqrs.Event) error
+//
+// or typed functions registered with On. Event argument and error result are
+// optional, event data might be passed by value or pointer.
+type Dispatcher struct {
+	events   Registry
+	handlers map[string]func(Event) error
+}
+
+// NewDispatcher creates Dispatcher of On<EventName> methods of v accepting
+// event data, it fails when any of them has signature not accepted by On.
+// Methods without parameters, like OnClose(), or with more than two, are not
+// handlers.
+func NewDispatcher(v interface{}) (*Dispatcher, error) {
+	d := &Dispatcher{
+		events:   Registry{},
+		handlers: make(map[string]func(Event) error),
+	}
+
+	if v == nil {
+		return d, nil
+	}
+
+	t, o := reflect.TypeOf(v), reflect.ValueOf(v)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if !strings.HasPrefix(m.Name, "On") || len(m.Name) == 2 || !unicode.IsUpper(rune(m.Name[2])) {
+			continue
+		}
+
+		if n := m.Type.NumIn() - 1; n == 0 || n > 2 {
+			continue
+		}
+
+		if err := d.On(o.Method(i).Interface()); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t, m.Name, err)
+		}
+	}
+
+	return d, nil
+}
+
+// On registers typed function handling one type of event, with signature
+// func(T), func(T) error, func(T, Event) or func(T, Event) error.
+func (d *Dispatcher) On(f interface{}) error {
+	v := reflect.ValueOf(f)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return fmt.Errorf("%s is not a function", t)
+	}
+
+	if t.NumIn() < 1 || t.NumIn() > 2 || (t.NumIn() == 2 && t.In(1) != eventType) {
+		return fmt.Errorf("%s must accept event data and optional cqrs.Event", t)
+	}
+
+	if t.NumOut() > 1 || (t.NumOut() == 1 && t.Out(0) != errorType) {
+		return fmt.Errorf("%s might return only error", t)
+	}
+
+	et := t.In(0)
+	if et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+
+	if et.Kind() == reflect.Interface {
+		return fmt.Errorf("%s event data must be concrete type", t)
+	}
+
+	return d.register(reflect.New(et).Elem().Interface(), func(e Event) error {
+		data, err := argument(e.Data, t.In(0))
+		if err != nil {
+			return fmt.Errorf("%s event: %s", e.Type, err)
+		}
+
+		in := []reflect.Value{data}
+		if t.NumIn() == 2 {
+			in = append(in, reflect.ValueOf(e))
+		}
+
+		out := v.Call(in)
+		if len(out) == 1 && !out[0].IsNil() {
+			return out[0].Interface().(error)
+		}
+
+		return nil
+	})
+}
+
+// Handle calls function registered for event, events without function are
+// ignored.
+func (d *Dispatcher) Handle(e Event) error {
+	f, ok := d.handlers[e.Type]
+	if !ok {
+		f, ok = d.handlers[name(e.Data)]
+	}
+
+	if !ok {
+		return nil
+	}
+
+	return f(e)
+}
+
+func (d *Dispatcher) register(event interface{}, f func(Event) error) error {
+	n := name(event)
+	if _, ok := d.handlers[n]; ok {
+		return fmt.Errorf("%s event already handled", n)
+	}
+
+	if err := d.events.Register(event, n); err != nil {
+		return err
+	}
+
+	d.handlers[n] = f
+	return nil
+}
+
+// Events returns Registry of handled events, ready to be used by Repository.
+func (d *Dispatcher) Events() Registry { return d.events }
+
+// Assign handled events to aggregate in Subscriptions.
+func (d *Dispatcher) Assign(ss Subscriptions, aggregate string) error {
+	return ss.Assign(aggregate, d.events.List()...)
+}
+
+// Unhandled returns names of events registered in given Registry, which have
+// no function, it should be checked at startup.
+func (d *Dispatcher) Unhandled(events Registry) []string {
+	var nn []string
+	for _, n := range events.Names() {
+		r, err := events.Resolve(n)
+		if err != nil || r != n {
+			continue
+		}
+
+		if _, ok := d.handlers[n]; !ok {
+			nn = append(nn, n)
+		}
+	}
+
+	sort.Strings(nn)
+	for _, n := range nn {
+		log.Info("cqrs.dispatcher", "%s event has no handler", n)
+	}
+
+	return nn
+}
+
+// argument converts event data into value or pointer expected by function.
+func argument(data interface{}, t reflect.Type) (reflect.Value, error) {
+	v := reflect.ValueOf(data)
+	if !v.IsValid() {
+		return v, fmt.Errorf("no data")
+	}
+
+	switch {
+	case v.Type() == t:
+		return v, nil
+	case t.Kind() == reflect.Ptr && v.Type() == t.Elem():
+		p := reflect.New(t.Elem())
+		p.Elem().Set(v)
+		return p, nil
+	case v.Kind() == reflect.Ptr && v.Type().Elem() == t:
+		if v.IsNil() {
+			return v, fmt.Errorf("nil data")
+		}
+		return v.Elem(), nil
+	default:
+		return v, fmt.Errorf("%s data given, %s expected", v.Type(), t)
+	}
+}
@@ -0,0 +1,130 @@
+package cqrs_test
+
+import (
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+)
+
+type shelf struct{ names []string }
+
+func (s *shelf) OnCreated(e Created) { s.names = append(s.names, e.Name) }
+
+func (s *shelf) OnRenamed(e *Renamed, m cqrs.Event) error {
+	s.names = append(s.names, m.Type+":"+e.Name)
+	return nil
+}
+
+// OnClose is not handler, it accepts no event.
+func (s *shelf) OnClose() {}
+
+type brokenShelf struct{}
+
+func (brokenShelf) OnCreated(e Created) string { return e.Name }
+
+func TestDispatcher(t *testing.T) {
+	s := &shelf{}
+	d, err := cqrs.NewDispatcher(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []cqrs.Event{{Type: "Created", Data: Created{Name: "Dune"}}, {Type: "Renamed", Data: Renamed{Name: "Emma"}}} {
+		if err := d.Handle(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(s.names) != 2 || s.names[0] != "Dune" || s.names[1] != "Renamed:Emma" {
+		t.Fatalf("both events handled expected, got %v", s.names)
+	}
+
+	if _, err := cqrs.NewDispatcher(brokenShelf{}); err == nil {
+		t.Fatal("method with wrong signature rejected expected")
+	}
+}
+
+func TestDispatcherOn(t *testing.T) {
+	cases := []struct {
+		desc string
+		f    interface{}
+		ok   bool
+	}{
+		{"value", func(Created) {}, true},
+		{"pointer with error", func(*Created) error { return nil }, true},
+		{"with event", func(Created, cqrs.Event) error { return nil }, true},
+		{"not function", "Created", false},
+		{"without event", func() {}, false},
+		{"second argument not event", func(Created, string) {}, false},
+		{"result not error", func(Created) string { return "" }, false},
+		{"interface data", func(interface{}) {}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			d, err := cqrs.NewDispatcher(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := d.On(c.f); (err == nil) != c.ok {
+				t.Fatalf("accepted %v expected, got %v", c.ok, err)
+			}
+
+			if !c.ok {
+				return
+			}
+
+			if err := d.On(c.f); err == nil {
+				t.Fatal("event handled twice rejected expected")
+			}
+
+			if err := d.Handle(cqrs.Event{Type: "Created", Data: Created{Name: "Dune"}}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDispatcherUnhandled(t *testing.T) {
+	d, err := cqrs.NewDispatcher(&shelf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Archived struct{}
+	events := cqrs.Registry{}.New(Created{}, Renamed{}, Archived{})
+	if err := events.Alias("Added", "Created"); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := d.Unhandled(events); len(u) != 1 || u[0] != "Archived" {
+		t.Fatalf("Archived event unhandled expected, got %v", u)
+	}
+
+	if u := d.Unhandled(d.Events()); len(u) != 0 {
+		t.Fatalf("all events handled expected, got %v", u)
+	}
+}
+
+func TestDispatcherAssign(t *testing.T) {
+	d, err := cqrs.NewDispatcher(&shelf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := cqrs.Subscriptions{}
+	if err := d.Assign(ss, "Book"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{"Created", "Renamed"} {
+		if !ss.Has(cqrs.Event{Aggregate: struct{ ID, Type string }{"dune", "Book"}, Type: e}) {
+			t.Fatalf("%s event of Book assigned expected, got %v", e, ss)
+		}
+	}
+
+	if ss.Has(cqrs.Event{Aggregate: struct{ ID, Type string }{"herbert", "Author"}, Type: "Created"}) {
+		t.Fatal("events of Author not assigned expected")
+	}
+}
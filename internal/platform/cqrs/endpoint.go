@@ -0,0 +1,144 @@
+package cqrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/sokool/gokit/log"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+// CommandEndpoint is an http.Handler dispatching commands sent as
+//
+//	POST /{aggregate}/{id}/{command}
+//
+// with JSON body decoded into command type registered in CommandBus of
+// aggregate. Response is written with status:
+//
+//	200 command handled, also when events were not published (ErrNotPublished)
+//	400 malformed request or command
+//	403 ErrUnauthorized
+//	404 aggregate or command not registered
+//	409 DomainError, or es.ErrConcurrency with Retry-After header
+//	422 ErrValidation
+//	500 any other error
+type CommandEndpoint struct {
+	buses   map[string]*CommandBus
+	headers []string
+	retry   string
+}
+
+func NewCommandEndpoint() *CommandEndpoint {
+	return &CommandEndpoint{
+		buses: make(map[string]*CommandBus),
+		retry: "1",
+	}
+}
+
+// Aggregate routes commands of aggregate to CommandBus.
+func (c *CommandEndpoint) Aggregate(name string, b *CommandBus) *CommandEndpoint {
+	c.buses[name] = b
+	return c
+}
+
+// Headers sets request headers passed as Meta. By default all headers are
+// passed, except Authorization and Cookie, since Meta is stored with events.
+func (c *CommandEndpoint) Headers(names ...string) *CommandEndpoint {
+	c.headers = names
+	return c
+}
+
+// RetryAfter sets seconds client should wait before sending command again,
+// when it failed due to concurrency conflict.
+func (c *CommandEndpoint) RetryAfter(seconds int) *CommandEndpoint {
+	c.retry = fmt.Sprint(seconds)
+	return c
+}
+
+func (c *CommandEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		c.write(w, http.StatusMethodNotAllowed, Response{Error: fmt.Errorf("method %s not allowed", r.Method)})
+		return
+	}
+
+	pp := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pp) != 3 || pp[0] == "" || pp[1] == "" || pp[2] == "" {
+		c.write(w, http.StatusNotFound, Response{Error: fmt.Errorf("path must be /{aggregate}/{id}/{command}")})
+		return
+	}
+
+	aggregate, id, command := pp[0], pp[1], pp[2]
+	b, ok := c.buses[aggregate]
+	if !ok {
+		c.write(w, http.StatusNotFound, Response{ID: id, Error: fmt.Errorf("aggregate %q not registered", aggregate)})
+		return
+	}
+
+	v, err := b.Command(command)
+	if err != nil {
+		c.write(w, http.StatusNotFound, Response{ID: id, Name: command, Error: err})
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil && err != io.EOF {
+		c.write(w, http.StatusBadRequest, Response{ID: id, Name: command, Error: fmt.Errorf("%s command decoding: %s", command, err)})
+		return
+	}
+
+	res := b.Handle(id, reflect.ValueOf(v).Elem().Interface(), c.meta(r))
+	c.write(w, c.status(w, res.Error), res)
+}
+
+func (c *CommandEndpoint) meta(r *http.Request) Meta {
+	m := MetaFromHTTP(r)
+	if len(c.headers) == 0 {
+		delete(m, "Authorization")
+		delete(m, "Cookie")
+		return m
+	}
+
+	o := make(Meta)
+	for _, h := range c.headers {
+		h = http.CanonicalHeaderKey(h)
+		if v, ok := m[h]; ok {
+			o[h] = v
+		}
+	}
+
+	return o
+}
+
+func (c *CommandEndpoint) status(w http.ResponseWriter, err error) int {
+	var d DomainError
+	switch {
+	case err == nil, errors.Is(err, ErrNotPublished):
+		return http.StatusOK
+	case errors.Is(err, ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusForbidden
+	case errors.Is(err, es.ErrConcurrency):
+		w.Header().Set("Retry-After", c.retry)
+		return http.StatusConflict
+	case errors.As(err, &d):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (c *CommandEndpoint) write(w http.ResponseWriter, status int, r Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		log.Error("cqrs.endpoint", err)
+	}
+}
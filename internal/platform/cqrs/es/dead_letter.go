@@ -0,0 +1,49 @@
+package es
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeadLetter is an Event which subscriber was not able to process. It is
+// parked in DeadLetters until it is retried or discarded.
+type DeadLetter struct {
+	ID         string
+	Subscriber string
+	Aggregate  Aggregate
+	Event      Event
+	Error      string
+	Attempts   uint
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func NewDeadLetter(subscriber string, a Aggregate, e Event, err error) DeadLetter {
+	now := time.Now()
+	return DeadLetter{
+		ID:         fmt.Sprintf("%s.%s.%s.%s.%d", subscriber, a.Type, a.ID, e.Type, e.Version),
+		Subscriber: subscriber,
+		Aggregate:  a,
+		Event:      e,
+		Error:      err.Error(),
+		Attempts:   1,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+func (d DeadLetter) String() string {
+	return fmt.Sprintf("%s %s.%s.%s[v.%d] attempts:%d %s",
+		d.ID, d.Aggregate.ID, d.Aggregate.Type, d.Event.Type, d.Event.Version, d.Attempts, d.Error)
+}
+
+// DeadLetters stores events parked by subscribers. Park called with ID which
+// is already parked, adds attempts and replaces error of existing DeadLetter.
+type DeadLetters interface {
+	Park(DeadLetter) error
+	// List parked events of given subscriber, or all of them when subscriber
+	// is empty.
+	List(subscriber string) ([]DeadLetter, error)
+	Get(id string) (DeadLetter, error)
+	Delete(id string) error
+}
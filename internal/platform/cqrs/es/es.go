@@ -1,7 +1,11 @@
 package es
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +22,22 @@ type PublishSubscriber interface {
 	Subscriber
 }
 
+// Serializer encodes events sent through brokers.
+type Serializer interface {
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(d []byte, o interface{}) error {
+	return json.Unmarshal(d, o)
+}
+
 type Aggregate struct {
 	ID   string
 	Type string
@@ -31,6 +51,9 @@ type Event struct {
 	Version uint
 	//Snapshot  bool
 	CreatedAt time.Time
+	// Position of event in storage, across all aggregates. Zero when
+	// storage does not track it.
+	Position uint64
 }
 
 type AggregateEvents struct {
@@ -56,6 +79,21 @@ func (a AggregateEvents) String() string {
 	return s
 }
 
+// envelope is a message sent through brokers, it carries event with its
+// aggregate.
+type envelope struct {
+	Aggregate Aggregate
+	Event
+}
+
+// ErrConcurrency is returned by Storage, when expected version of aggregate
+// is not its stored version, so events were appended by someone else.
+var ErrConcurrency = errors.New("concurrency conflict")
+
+func conflict(a Aggregate, expected uint) error {
+	return fmt.Errorf("%w: %s.%s is not in version %d", ErrConcurrency, a.ID, a.Type, expected)
+}
+
 type Storage interface {
 	Append(AggregateEvents, uint) error
 	FromVersion(Aggregate, uint) (AggregateEvents, error)
@@ -65,17 +103,23 @@ type Storage interface {
 	All(aggregate string) ([]AggregateEvents, error)
 	//ByDate(id, aggregate string, from time.Time) ([]Event, error)
 	//Stream([]Query) ([]Event, error)
+	// Copy appends events of src aggregate from given version to dst
+	// aggregate, numbered after its last version.
 	Copy(aggregate, src string, from uint, dst string) error
 }
 
 type Subscription struct {
 	name          string
+	group         string
 	routes        []string
 	subscriptions map[string]map[string]bool
-	handler       func(Aggregate, Event)
+	handler       func(Aggregate, Event) error
 }
 
-func NewSubscription(h func(Aggregate, Event)) *Subscription {
+// NewSubscription creates Subscription with handler called for every delivered
+// event. Error returned by handler tells Subscriber that event was not
+// processed, what it does with such event depends on implementation.
+func NewSubscription(h func(Aggregate, Event) error) *Subscription {
 	return &Subscription{
 		handler:       h,
 		subscriptions: make(map[string]map[string]bool),
@@ -84,6 +128,11 @@ func NewSubscription(h func(Aggregate, Event)) *Subscription {
 
 func (s *Subscription) Name(n string) *Subscription { s.name = n; return s }
 
+// Group makes Subscription a member of consumer group, which members share
+// events partitioned by aggregate ID, so events of one aggregate are handled
+// by one member in order. Every member needs its own Name.
+func (s *Subscription) Group(n string) *Subscription { s.group = n; return s }
+
 func (s *Subscription) AggregateEvents(aggregate string, events ...string) *Subscription {
 	if len(events) == 0 {
 		s.routes = append(s.routes, route("*", aggregate, "*"))
@@ -105,6 +154,34 @@ func (s *Subscription) handle(a AggregateEvents) {
 
 }
 
+// partition of aggregate ID, in range [0, n).
+func partition(id string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+
+	return int(h.Sum32() % uint32(n))
+}
+
+// route builds topic routing key, where id, aggregate and event are escaped,
+// so dots in them do not split it into more words.
 func route(id, aggregate, event string) string {
-	return fmt.Sprintf("%s.%s.%s", id, aggregate, event)
+	return fmt.Sprintf("%s.%s.%s", escape(id), escape(aggregate), escape(event))
+}
+
+var (
+	escaper   = strings.NewReplacer("%", "%25", ".", "%2E", "*", "%2A", "#", "%23")
+	unescaper = strings.NewReplacer("%2E", ".", "%2A", "*", "%23", "#", "%25", "%")
+)
+
+// escape routing key word, except "*" wildcard.
+func escape(word string) string {
+	if word == "*" {
+		return word
+	}
+
+	return escaper.Replace(word)
+}
+
+func unescape(word string) string {
+	return unescaper.Replace(word)
 }
@@ -5,28 +5,26 @@ import (
 	"testing"
 	"time"
 
-	"github.com/icrowley/fake"
-	"github.com/livechat/developers-platform-service/internal/platform/cqrs/es"
-	"github.com/sokool/gokit/test/is"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
 )
 
 func TestPubX(t *testing.T) {
 	cases := []struct {
 		desc    string
 		name    string
-		listen  []es.Subscription
+		listen  []es.AggregateEvents
 		publish []es.AggregateEvents
 		expects []es.AggregateEvents
 	}{
 		{
 			desc: "some example",
 			name: "xyz",
-			listen: []es.Subscription{
-				subscription("", "User", "Deleted", "Created"),
-				subscription("", "Profile", "EmailChanged", "Banned")},
+			listen: []es.AggregateEvents{
+				events("", "User", "Deleted", "Created"),
+				events("", "Profile", "EmailChanged", "Banned")},
 			publish: []es.AggregateEvents{
 				events("y", "User", "Created", "NameAdded", "AddressAdded", "Deleted"),
-				events("X", "Profile", "Accepted", "EmailChanged", "AvatarChanged", "Banned", "Removed"),
+				events("x", "Profile", "Accepted", "EmailChanged", "AvatarChanged", "Banned", "Removed"),
 				events("z", "User", "Deleted")},
 			expects: []es.AggregateEvents{
 				events("y", "User", "Created", "Deleted"),
@@ -36,25 +34,35 @@ func TestPubX(t *testing.T) {
 	}
 
 	ps := es.NewMemPubSub()
+	defer ps.Close()
+
 	for _, c := range cases {
 		t.Run(c.desc, func(t *testing.T) {
-			is.Ok(t, ps.Subscribe(c.name, handle(c.expects), c.listen...))
+			received := make(chan es.AggregateEvents, 64)
+			s := es.NewSubscription(collect(received)).Name(c.name)
+			for _, l := range c.listen {
+				s.AggregateEvents(l.Type, types(l)...)
+			}
+
+			if err := ps.Subscribe(*s); err != nil {
+				t.Fatal(err)
+			}
+
 			for _, e := range c.publish {
-				is.Ok(t, ps.Publish(e))
+				if err := ps.Publish(e); err != nil {
+					t.Fatal(err)
+				}
 			}
 
+			for _, a := range c.expects {
+				for _, e := range a.Events {
+					expect(t, received, fmt.Sprintf("%s.%s.%s", a.ID, a.Type, e.Type))
+				}
+			}
 		})
 	}
 }
 
-func handle(ee ...[]es.AggregateEvents) func(es.Aggregate, es.Event) error {
-	i := len(ee)
-	return func(a es.Aggregate, e es.Event) error {
-		fmt.Println(i, a, e.Type, e.Version)
-		return nil
-	}
-}
-
 func events(id, aggregate string, events ...string) es.AggregateEvents {
 	var o es.AggregateEvents
 
@@ -64,8 +72,8 @@ func events(id, aggregate string, events ...string) es.AggregateEvents {
 
 	for i := range events {
 		o.Events = append(o.Events, es.Event{
-			Data:      []byte(fake.ParagraphsN(1)),
-			Meta:      []byte(fake.Paragraph()),
+			Data:      []byte(`{}`),
+			Meta:      []byte(`{}`),
 			Type:      events[i],
 			Version:   uint(i + 1),
 			CreatedAt: time.Now(),
@@ -75,10 +83,11 @@ func events(id, aggregate string, events ...string) es.AggregateEvents {
 	return o
 }
 
-func subscription(id, aggregate string, events ...string) es.Subscription {
-	return es.Subscription{
-		ID:        id,
-		Aggregate: aggregate,
-		Events:    events,
+func types(a es.AggregateEvents) []string {
+	var tt []string
+	for _, e := range a.Events {
+		tt = append(tt, e.Type)
 	}
+
+	return tt
 }
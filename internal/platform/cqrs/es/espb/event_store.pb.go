@@ -0,0 +1,814 @@
+// EventStore service served by es.NewGRPCServer, timestamps are RFC 3339
+// strings.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: event_store.proto
+
+package espb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Aggregate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Aggregate) Reset() {
+	*x = Aggregate{}
+	mi := &file_event_store_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Aggregate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Aggregate) ProtoMessage() {}
+
+func (x *Aggregate) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Aggregate.ProtoReflect.Descriptor instead.
+func (*Aggregate) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Aggregate) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Aggregate) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Version       uint64                 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	Meta          []byte                 `protobuf:"bytes,4,opt,name=meta,proto3" json:"meta,omitempty"`
+	CreatedAt     string                 `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Position      uint64                 `protobuf:"varint,6,opt,name=position,proto3" json:"position,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_event_store_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Event) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Event) GetMeta() []byte {
+	if x != nil {
+		return x.Meta
+	}
+	return nil
+}
+
+func (x *Event) GetCreatedAt() string {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return ""
+}
+
+func (x *Event) GetPosition() uint64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+type Stream struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Aggregate     *Aggregate             `protobuf:"bytes,1,opt,name=aggregate,proto3" json:"aggregate,omitempty"`
+	Events        []*Event               `protobuf:"bytes,2,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stream) Reset() {
+	*x = Stream{}
+	mi := &file_event_store_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stream) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stream) ProtoMessage() {}
+
+func (x *Stream) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stream.ProtoReflect.Descriptor instead.
+func (*Stream) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Stream) GetAggregate() *Aggregate {
+	if x != nil {
+		return x.Aggregate
+	}
+	return nil
+}
+
+func (x *Stream) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type Streams struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Streams       []*Stream              `protobuf:"bytes,1,rep,name=streams,proto3" json:"streams,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Streams) Reset() {
+	*x = Streams{}
+	mi := &file_event_store_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Streams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Streams) ProtoMessage() {}
+
+func (x *Streams) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Streams.ProtoReflect.Descriptor instead.
+func (*Streams) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Streams) GetStreams() []*Stream {
+	if x != nil {
+		return x.Streams
+	}
+	return nil
+}
+
+type AppendRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Aggregate       *Aggregate             `protobuf:"bytes,1,opt,name=aggregate,proto3" json:"aggregate,omitempty"`
+	ExpectedVersion uint64                 `protobuf:"varint,2,opt,name=expected_version,json=expectedVersion,proto3" json:"expected_version,omitempty"`
+	Events          []*Event               `protobuf:"bytes,3,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *AppendRequest) Reset() {
+	*x = AppendRequest{}
+	mi := &file_event_store_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendRequest) ProtoMessage() {}
+
+func (x *AppendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendRequest.ProtoReflect.Descriptor instead.
+func (*AppendRequest) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AppendRequest) GetAggregate() *Aggregate {
+	if x != nil {
+		return x.Aggregate
+	}
+	return nil
+}
+
+func (x *AppendRequest) GetExpectedVersion() uint64 {
+	if x != nil {
+		return x.ExpectedVersion
+	}
+	return 0
+}
+
+func (x *AppendRequest) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type ReadStreamRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Aggregate     *Aggregate             `protobuf:"bytes,1,opt,name=aggregate,proto3" json:"aggregate,omitempty"`
+	FromVersion   uint64                 `protobuf:"varint,2,opt,name=from_version,json=fromVersion,proto3" json:"from_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadStreamRequest) Reset() {
+	*x = ReadStreamRequest{}
+	mi := &file_event_store_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadStreamRequest) ProtoMessage() {}
+
+func (x *ReadStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadStreamRequest.ProtoReflect.Descriptor instead.
+func (*ReadStreamRequest) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ReadStreamRequest) GetAggregate() *Aggregate {
+	if x != nil {
+		return x.Aggregate
+	}
+	return nil
+}
+
+func (x *ReadStreamRequest) GetFromVersion() uint64 {
+	if x != nil {
+		return x.FromVersion
+	}
+	return 0
+}
+
+type ReadAggregateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadAggregateRequest) Reset() {
+	*x = ReadAggregateRequest{}
+	mi := &file_event_store_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadAggregateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadAggregateRequest) ProtoMessage() {}
+
+func (x *ReadAggregateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadAggregateRequest.ProtoReflect.Descriptor instead.
+func (*ReadAggregateRequest) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ReadAggregateRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+type CopyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Source        string                 `protobuf:"bytes,2,opt,name=source,proto3" json:"source,omitempty"`
+	FromVersion   uint64                 `protobuf:"varint,3,opt,name=from_version,json=fromVersion,proto3" json:"from_version,omitempty"`
+	Destination   string                 `protobuf:"bytes,4,opt,name=destination,proto3" json:"destination,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CopyRequest) Reset() {
+	*x = CopyRequest{}
+	mi := &file_event_store_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CopyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CopyRequest) ProtoMessage() {}
+
+func (x *CopyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CopyRequest.ProtoReflect.Descriptor instead.
+func (*CopyRequest) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CopyRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *CopyRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *CopyRequest) GetFromVersion() uint64 {
+	if x != nil {
+		return x.FromVersion
+	}
+	return 0
+}
+
+func (x *CopyRequest) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+type SubscribeRequest struct {
+	state         protoimpl.MessageState     `protogen:"open.v1"`
+	Name          string                     `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Group         string                     `protobuf:"bytes,2,opt,name=group,proto3" json:"group,omitempty"`
+	Filters       []*SubscribeRequest_Filter `protobuf:"bytes,3,rep,name=filters,proto3" json:"filters,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_event_store_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SubscribeRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetFilters() []*SubscribeRequest_Filter {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+type Delivery struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Aggregate     *Aggregate             `protobuf:"bytes,1,opt,name=aggregate,proto3" json:"aggregate,omitempty"`
+	Event         *Event                 `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Delivery) Reset() {
+	*x = Delivery{}
+	mi := &file_event_store_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Delivery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Delivery) ProtoMessage() {}
+
+func (x *Delivery) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Delivery.ProtoReflect.Descriptor instead.
+func (*Delivery) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Delivery) GetAggregate() *Aggregate {
+	if x != nil {
+		return x.Aggregate
+	}
+	return nil
+}
+
+func (x *Delivery) GetEvent() *Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+type Empty struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Empty) Reset() {
+	*x = Empty{}
+	mi := &file_event_store_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Empty) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Empty) ProtoMessage() {}
+
+func (x *Empty) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
+func (*Empty) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{10}
+}
+
+type SubscribeRequest_Filter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Aggregate     string                 `protobuf:"bytes,1,opt,name=aggregate,proto3" json:"aggregate,omitempty"`
+	Events        []string               `protobuf:"bytes,2,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest_Filter) Reset() {
+	*x = SubscribeRequest_Filter{}
+	mi := &file_event_store_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest_Filter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest_Filter) ProtoMessage() {}
+
+func (x *SubscribeRequest_Filter) ProtoReflect() protoreflect.Message {
+	mi := &file_event_store_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest_Filter.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest_Filter) Descriptor() ([]byte, []int) {
+	return file_event_store_proto_rawDescGZIP(), []int{8, 0}
+}
+
+func (x *SubscribeRequest_Filter) GetAggregate() string {
+	if x != nil {
+		return x.Aggregate
+	}
+	return ""
+}
+
+func (x *SubscribeRequest_Filter) GetEvents() []string {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+var File_event_store_proto protoreflect.FileDescriptor
+
+const file_event_store_proto_rawDesc = "" +
+	"\n" +
+	"\x11event_store.proto\x12\acqrs.es\"/\n" +
+	"\tAggregate\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\"\x98\x01\n" +
+	"\x05Event\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x04R\aversion\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12\x12\n" +
+	"\x04meta\x18\x04 \x01(\fR\x04meta\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\tR\tcreatedAt\x12\x1a\n" +
+	"\bposition\x18\x06 \x01(\x04R\bposition\"b\n" +
+	"\x06Stream\x120\n" +
+	"\taggregate\x18\x01 \x01(\v2\x12.cqrs.es.AggregateR\taggregate\x12&\n" +
+	"\x06events\x18\x02 \x03(\v2\x0e.cqrs.es.EventR\x06events\"4\n" +
+	"\aStreams\x12)\n" +
+	"\astreams\x18\x01 \x03(\v2\x0f.cqrs.es.StreamR\astreams\"\x94\x01\n" +
+	"\rAppendRequest\x120\n" +
+	"\taggregate\x18\x01 \x01(\v2\x12.cqrs.es.AggregateR\taggregate\x12)\n" +
+	"\x10expected_version\x18\x02 \x01(\x04R\x0fexpectedVersion\x12&\n" +
+	"\x06events\x18\x03 \x03(\v2\x0e.cqrs.es.EventR\x06events\"h\n" +
+	"\x11ReadStreamRequest\x120\n" +
+	"\taggregate\x18\x01 \x01(\v2\x12.cqrs.es.AggregateR\taggregate\x12!\n" +
+	"\ffrom_version\x18\x02 \x01(\x04R\vfromVersion\"*\n" +
+	"\x14ReadAggregateRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\"~\n" +
+	"\vCopyRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x16\n" +
+	"\x06source\x18\x02 \x01(\tR\x06source\x12!\n" +
+	"\ffrom_version\x18\x03 \x01(\x04R\vfromVersion\x12 \n" +
+	"\vdestination\x18\x04 \x01(\tR\vdestination\"\xb8\x01\n" +
+	"\x10SubscribeRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05group\x18\x02 \x01(\tR\x05group\x12:\n" +
+	"\afilters\x18\x03 \x03(\v2 .cqrs.es.SubscribeRequest.FilterR\afilters\x1a>\n" +
+	"\x06Filter\x12\x1c\n" +
+	"\taggregate\x18\x01 \x01(\tR\taggregate\x12\x16\n" +
+	"\x06events\x18\x02 \x03(\tR\x06events\"b\n" +
+	"\bDelivery\x120\n" +
+	"\taggregate\x18\x01 \x01(\v2\x12.cqrs.es.AggregateR\taggregate\x12$\n" +
+	"\x05event\x18\x02 \x01(\v2\x0e.cqrs.es.EventR\x05event\"\a\n" +
+	"\x05Empty2\xa7\x02\n" +
+	"\n" +
+	"EventStore\x121\n" +
+	"\x06Append\x12\x16.cqrs.es.AppendRequest\x1a\x0f.cqrs.es.Stream\x129\n" +
+	"\n" +
+	"ReadStream\x12\x1a.cqrs.es.ReadStreamRequest\x1a\x0f.cqrs.es.Stream\x12@\n" +
+	"\rReadAggregate\x12\x1d.cqrs.es.ReadAggregateRequest\x1a\x10.cqrs.es.Streams\x12,\n" +
+	"\x04Copy\x12\x14.cqrs.es.CopyRequest\x1a\x0e.cqrs.es.Empty\x12;\n" +
+	"\tSubscribe\x12\x19.cqrs.es.SubscribeRequest\x1a\x11.cqrs.es.Delivery0\x01B9Z7github.com/sokool/shelf2/internal/platform/cqrs/es/espbb\x06proto3"
+
+var (
+	file_event_store_proto_rawDescOnce sync.Once
+	file_event_store_proto_rawDescData []byte
+)
+
+func file_event_store_proto_rawDescGZIP() []byte {
+	file_event_store_proto_rawDescOnce.Do(func() {
+		file_event_store_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_event_store_proto_rawDesc), len(file_event_store_proto_rawDesc)))
+	})
+	return file_event_store_proto_rawDescData
+}
+
+var file_event_store_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_event_store_proto_goTypes = []any{
+	(*Aggregate)(nil),               // 0: cqrs.es.Aggregate
+	(*Event)(nil),                   // 1: cqrs.es.Event
+	(*Stream)(nil),                  // 2: cqrs.es.Stream
+	(*Streams)(nil),                 // 3: cqrs.es.Streams
+	(*AppendRequest)(nil),           // 4: cqrs.es.AppendRequest
+	(*ReadStreamRequest)(nil),       // 5: cqrs.es.ReadStreamRequest
+	(*ReadAggregateRequest)(nil),    // 6: cqrs.es.ReadAggregateRequest
+	(*CopyRequest)(nil),             // 7: cqrs.es.CopyRequest
+	(*SubscribeRequest)(nil),        // 8: cqrs.es.SubscribeRequest
+	(*Delivery)(nil),                // 9: cqrs.es.Delivery
+	(*Empty)(nil),                   // 10: cqrs.es.Empty
+	(*SubscribeRequest_Filter)(nil), // 11: cqrs.es.SubscribeRequest.Filter
+}
+var file_event_store_proto_depIdxs = []int32{
+	0,  // 0: cqrs.es.Stream.aggregate:type_name -> cqrs.es.Aggregate
+	1,  // 1: cqrs.es.Stream.events:type_name -> cqrs.es.Event
+	2,  // 2: cqrs.es.Streams.streams:type_name -> cqrs.es.Stream
+	0,  // 3: cqrs.es.AppendRequest.aggregate:type_name -> cqrs.es.Aggregate
+	1,  // 4: cqrs.es.AppendRequest.events:type_name -> cqrs.es.Event
+	0,  // 5: cqrs.es.ReadStreamRequest.aggregate:type_name -> cqrs.es.Aggregate
+	11, // 6: cqrs.es.SubscribeRequest.filters:type_name -> cqrs.es.SubscribeRequest.Filter
+	0,  // 7: cqrs.es.Delivery.aggregate:type_name -> cqrs.es.Aggregate
+	1,  // 8: cqrs.es.Delivery.event:type_name -> cqrs.es.Event
+	4,  // 9: cqrs.es.EventStore.Append:input_type -> cqrs.es.AppendRequest
+	5,  // 10: cqrs.es.EventStore.ReadStream:input_type -> cqrs.es.ReadStreamRequest
+	6,  // 11: cqrs.es.EventStore.ReadAggregate:input_type -> cqrs.es.ReadAggregateRequest
+	7,  // 12: cqrs.es.EventStore.Copy:input_type -> cqrs.es.CopyRequest
+	8,  // 13: cqrs.es.EventStore.Subscribe:input_type -> cqrs.es.SubscribeRequest
+	2,  // 14: cqrs.es.EventStore.Append:output_type -> cqrs.es.Stream
+	2,  // 15: cqrs.es.EventStore.ReadStream:output_type -> cqrs.es.Stream
+	3,  // 16: cqrs.es.EventStore.ReadAggregate:output_type -> cqrs.es.Streams
+	10, // 17: cqrs.es.EventStore.Copy:output_type -> cqrs.es.Empty
+	9,  // 18: cqrs.es.EventStore.Subscribe:output_type -> cqrs.es.Delivery
+	14, // [14:19] is the sub-list for method output_type
+	9,  // [9:14] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_event_store_proto_init() }
+func file_event_store_proto_init() {
+	if File_event_store_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_event_store_proto_rawDesc), len(file_event_store_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_event_store_proto_goTypes,
+		DependencyIndexes: file_event_store_proto_depIdxs,
+		MessageInfos:      file_event_store_proto_msgTypes,
+	}.Build()
+	File_event_store_proto = out.File
+	file_event_store_proto_goTypes = nil
+	file_event_store_proto_depIdxs = nil
+}
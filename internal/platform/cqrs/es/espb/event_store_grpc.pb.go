@@ -0,0 +1,294 @@
+// EventStore service served by es.NewGRPCServer, timestamps are RFC 3339
+// strings.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: event_store.proto
+
+package espb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	EventStore_Append_FullMethodName        = "/cqrs.es.EventStore/Append"
+	EventStore_ReadStream_FullMethodName    = "/cqrs.es.EventStore/ReadStream"
+	EventStore_ReadAggregate_FullMethodName = "/cqrs.es.EventStore/ReadAggregate"
+	EventStore_Copy_FullMethodName          = "/cqrs.es.EventStore/Copy"
+	EventStore_Subscribe_FullMethodName     = "/cqrs.es.EventStore/Subscribe"
+)
+
+// EventStoreClient is the client API for EventStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EventStoreClient interface {
+	// Append events to aggregate stream, events are returned with versions
+	// and creation time given by store.
+	Append(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*Stream, error)
+	// ReadStream returns events of aggregate, starting from given version.
+	ReadStream(ctx context.Context, in *ReadStreamRequest, opts ...grpc.CallOption) (*Stream, error)
+	// ReadAggregate returns streams of all aggregates of given type.
+	ReadAggregate(ctx context.Context, in *ReadAggregateRequest, opts ...grpc.CallOption) (*Streams, error)
+	// Copy events of source aggregate, starting from given version, into
+	// destination aggregate.
+	Copy(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (*Empty, error)
+	// Subscribe streams events published after subscription is made.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Delivery], error)
+}
+
+type eventStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventStoreClient(cc grpc.ClientConnInterface) EventStoreClient {
+	return &eventStoreClient{cc}
+}
+
+func (c *eventStoreClient) Append(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*Stream, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Stream)
+	err := c.cc.Invoke(ctx, EventStore_Append_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventStoreClient) ReadStream(ctx context.Context, in *ReadStreamRequest, opts ...grpc.CallOption) (*Stream, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Stream)
+	err := c.cc.Invoke(ctx, EventStore_ReadStream_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventStoreClient) ReadAggregate(ctx context.Context, in *ReadAggregateRequest, opts ...grpc.CallOption) (*Streams, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Streams)
+	err := c.cc.Invoke(ctx, EventStore_ReadAggregate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventStoreClient) Copy(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, EventStore_Copy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventStoreClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Delivery], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &EventStore_ServiceDesc.Streams[0], EventStore_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, Delivery]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type EventStore_SubscribeClient = grpc.ServerStreamingClient[Delivery]
+
+// EventStoreServer is the server API for EventStore service.
+// All implementations must embed UnimplementedEventStoreServer
+// for forward compatibility.
+type EventStoreServer interface {
+	// Append events to aggregate stream, events are returned with versions
+	// and creation time given by store.
+	Append(context.Context, *AppendRequest) (*Stream, error)
+	// ReadStream returns events of aggregate, starting from given version.
+	ReadStream(context.Context, *ReadStreamRequest) (*Stream, error)
+	// ReadAggregate returns streams of all aggregates of given type.
+	ReadAggregate(context.Context, *ReadAggregateRequest) (*Streams, error)
+	// Copy events of source aggregate, starting from given version, into
+	// destination aggregate.
+	Copy(context.Context, *CopyRequest) (*Empty, error)
+	// Subscribe streams events published after subscription is made.
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Delivery]) error
+	mustEmbedUnimplementedEventStoreServer()
+}
+
+// UnimplementedEventStoreServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedEventStoreServer struct{}
+
+func (UnimplementedEventStoreServer) Append(context.Context, *AppendRequest) (*Stream, error) {
+	return nil, status.Error(codes.Unimplemented, "method Append not implemented")
+}
+func (UnimplementedEventStoreServer) ReadStream(context.Context, *ReadStreamRequest) (*Stream, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReadStream not implemented")
+}
+func (UnimplementedEventStoreServer) ReadAggregate(context.Context, *ReadAggregateRequest) (*Streams, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReadAggregate not implemented")
+}
+func (UnimplementedEventStoreServer) Copy(context.Context, *CopyRequest) (*Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method Copy not implemented")
+}
+func (UnimplementedEventStoreServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Delivery]) error {
+	return status.Error(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedEventStoreServer) mustEmbedUnimplementedEventStoreServer() {}
+func (UnimplementedEventStoreServer) testEmbeddedByValue()                    {}
+
+// UnsafeEventStoreServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EventStoreServer will
+// result in compilation errors.
+type UnsafeEventStoreServer interface {
+	mustEmbedUnimplementedEventStoreServer()
+}
+
+func RegisterEventStoreServer(s grpc.ServiceRegistrar, srv EventStoreServer) {
+	// If the following call panics, it indicates UnimplementedEventStoreServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&EventStore_ServiceDesc, srv)
+}
+
+func _EventStore_Append_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventStoreServer).Append(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EventStore_Append_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventStoreServer).Append(ctx, req.(*AppendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventStore_ReadStream_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadStreamRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventStoreServer).ReadStream(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EventStore_ReadStream_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventStoreServer).ReadStream(ctx, req.(*ReadStreamRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventStore_ReadAggregate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadAggregateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventStoreServer).ReadAggregate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EventStore_ReadAggregate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventStoreServer).ReadAggregate(ctx, req.(*ReadAggregateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventStore_Copy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CopyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventStoreServer).Copy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EventStore_Copy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventStoreServer).Copy(ctx, req.(*CopyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventStore_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EventStoreServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, Delivery]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type EventStore_SubscribeServer = grpc.ServerStreamingServer[Delivery]
+
+// EventStore_ServiceDesc is the grpc.ServiceDesc for EventStore service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EventStore_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cqrs.es.EventStore",
+	HandlerType: (*EventStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Append",
+			Handler:    _EventStore_Append_Handler,
+		},
+		{
+			MethodName: "ReadStream",
+			Handler:    _EventStore_ReadStream_Handler,
+		},
+		{
+			MethodName: "ReadAggregate",
+			Handler:    _EventStore_ReadAggregate_Handler,
+		},
+		{
+			MethodName: "Copy",
+			Handler:    _EventStore_Copy_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _EventStore_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "event_store.proto",
+}
@@ -0,0 +1,274 @@
+package es
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sokool/gokit/log"
+)
+
+const ftag = "es.feed"
+
+// Feed reads events of all aggregates in order they were stored.
+type Feed interface {
+	// After returns up to limit events stored after given position, events of
+	// the same aggregate following each other are grouped in one
+	// AggregateEvents.
+	After(position uint64, limit int) ([]AggregateEvents, error)
+	// Head is position of last stored event.
+	Head() (uint64, error)
+}
+
+// Positions remembers position of Feed processed by named subscription.
+type Positions interface {
+	Position(subscription string) (uint64, bool, error)
+	SetPosition(subscription string, position uint64) error
+}
+
+// feedSubscriber delivers events by reading Feed from last position of every
+// subscription, whenever it is notified about new events or when interval
+// elapses.
+type feedSubscriber struct {
+	feed      Feed
+	positions Positions
+	interval  time.Duration
+	batch     int
+	gap       time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	wakeups []chan struct{}
+}
+
+// NewPollingSubscriber reads Feed every interval. It works with any database
+// holding events, without broker nor database notifications.
+func NewPollingSubscriber(f Feed, p Positions, interval time.Duration) *feedSubscriber {
+	s := &feedSubscriber{
+		feed:      f,
+		positions: p,
+		interval:  interval,
+		batch:     500,
+		gap:       5 * time.Second,
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Gap is how long subscription waits for missing position, before it skips
+// it. Position is missing, when transaction which stored event is not
+// committed yet, or when it was rolled back or failed, which leaves gap for
+// ever. Gap followed by event stored earlier than that is skipped at once,
+// so reading older events does not wait for every gap. Default is 5 seconds,
+// events committed later than that are never delivered.
+func (f *feedSubscriber) Gap(d time.Duration) *feedSubscriber { f.gap = d; return f }
+
+// Subscribe starts reading Feed from position remembered for Subscription
+// name, or from head, when subscription is new or has no name. Position of
+// subscription without name is not remembered.
+func (f *feedSubscriber) Subscribe(s Subscription) error {
+	var position uint64
+	var ok bool
+	var err error
+	if s.name != "" {
+		if position, ok, err = f.positions.Position(s.name); err != nil {
+			return fmt.Errorf("%s position %s", s.name, err)
+		}
+	}
+
+	if !ok {
+		if position, err = f.feed.Head(); err != nil {
+			return fmt.Errorf("%s head %s", s.name, err)
+		}
+	}
+
+	wakeup := make(chan struct{}, 1)
+	f.mu.Lock()
+	f.wakeups = append(f.wakeups, wakeup)
+	f.mu.Unlock()
+
+	f.wg.Add(1)
+	go f.read(s, position, wakeup)
+
+	log.Debug(ftag, "%s reads from position %d", s.name, position)
+	return nil
+}
+
+// Notify wakes up all subscriptions, so they read new events without waiting
+// for interval.
+func (f *feedSubscriber) Notify() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, w := range f.wakeups {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close stops reading and waits until handlers process delivered events.
+func (f *feedSubscriber) Close() error {
+	f.cancel()
+	f.wg.Wait()
+
+	return nil
+}
+
+func (f *feedSubscriber) read(s Subscription, position uint64, wakeup <-chan struct{}) {
+	defer f.wg.Done()
+
+	t := time.NewTicker(f.interval)
+	defer t.Stop()
+
+	var gap time.Time
+	for {
+		for {
+			next, more := f.deliver(s, position, &gap)
+			if next != position && s.name != "" {
+				if err := f.positions.SetPosition(s.name, next); err != nil {
+					log.Error(ftag, fmt.Errorf("%s position %s", s.name, err))
+				}
+			}
+
+			position = next
+
+			if !more || f.ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-wakeup:
+		case <-t.C:
+		}
+	}
+}
+
+// deliver one batch of events after position, returns position of last event
+// handled and whether there might be more events to read. Handling stops on
+// first failed event, so it is delivered again in order, and on missing
+// position, until it is filled or gap, which was noticed at given time, or
+// event following it is older than Gap. Subscription of "*" aggregate receives
+// events of aggregates it does not subscribe by type.
+func (f *feedSubscriber) deliver(s Subscription, position uint64, gap *time.Time) (uint64, bool) {
+	aa, err := f.feed.After(position, f.batch)
+	if err != nil {
+		log.Error(ftag, fmt.Errorf("%s read %s", s.name, err))
+		return position, false
+	}
+
+	var n int
+	for _, a := range aa {
+		events, ok := s.subscriptions[a.Type]
+		if !ok {
+			events, ok = s.subscriptions["*"]
+		}
+
+		for _, e := range a.Events {
+			n++
+			if e.Position > position+1 {
+				if gap.IsZero() {
+					*gap = time.Now()
+				}
+
+				if time.Since(*gap) < f.gap && time.Since(e.CreatedAt) < f.gap {
+					return position, false
+				}
+
+				log.Error(ftag, fmt.Errorf("%s positions %d-%d skipped after %s", s.name, position+1, e.Position-1, f.gap))
+			}
+
+			*gap = time.Time{}
+			if ok && (len(events) == 0 || events[e.Type]) {
+				if err := s.handler(a.Aggregate, e); err != nil {
+					log.Error(ftag, fmt.Errorf("%s %s.%s.%s[v.%d] %s", s.name, a.ID, a.Type, e.Type, e.Version, err))
+					return position, false
+				}
+			}
+
+			position = e.Position
+		}
+	}
+
+	return position, n == f.batch
+}
+
+type memPositions struct {
+	mu        sync.Mutex
+	positions map[string]uint64
+}
+
+func NewMemPositions() Positions {
+	return &memPositions{positions: make(map[string]uint64)}
+}
+
+func (m *memPositions) Position(subscription string) (uint64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.positions[subscription]
+	return p, ok, nil
+}
+
+func (m *memPositions) SetPosition(subscription string, position uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.positions[subscription] = position
+	return nil
+}
+
+// NewSQLFeed reads events from MySQL cqrs_events table with position column,
+// as created (or migrated) by MySQL.Create.
+func NewSQLFeed(db *sql.DB) Feed {
+	return &MySQL{db: db}
+}
+
+// readFeed reads events with given query, grouping events of the same aggregate
+// which follow each other.
+func readFeed(db *sql.DB, query string, args ...interface{}) ([]AggregateEvents, error) {
+	r, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	var out []AggregateEvents
+	for r.Next() {
+		var a Aggregate
+		var t interface{}
+		e := Event{}
+		if err := r.Scan(&a.ID, &a.Type, &e.Type, &e.Version, &t, &e.Data, &e.Meta, &e.Position); err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case time.Time:
+			e.CreatedAt = t
+		case []byte:
+			e.CreatedAt, _ = time.ParseInLocation("2006-01-02 15:04:05", string(t), time.Local)
+		case string:
+			e.CreatedAt, _ = time.ParseInLocation("2006-01-02 15:04:05", t, time.Local)
+		}
+
+		if n := len(out); n > 0 && out[n-1].Aggregate == a {
+			out[n-1].Events = append(out[n-1].Events, e)
+			continue
+		}
+
+		out = append(out, AggregateEvents{Aggregate: a, Events: []Event{e}})
+	}
+
+	return out, r.Err()
+}
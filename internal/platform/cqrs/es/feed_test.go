@@ -0,0 +1,113 @@
+package es_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestFeedGap(t *testing.T) {
+	f := &feed{}
+	p := es.NewMemPositions()
+	events := make(chan es.AggregateEvents, 16)
+
+	s := es.NewPollingSubscriber(f, p, time.Millisecond).Gap(time.Hour)
+	defer s.Close()
+
+	if err := s.Subscribe(*es.NewSubscription(collect(events)).Name("books").AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Subscribe(*es.NewSubscription(collect(make(chan es.AggregateEvents, 16))).AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	f.add(1, "dune", "Created")
+	f.add(3, "dune", "Archived")
+	expect(t, events, "dune.Book.Created")
+
+	select {
+	case a := <-events:
+		t.Fatalf("waiting for position 2 expected, got %s", a)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	f.add(2, "dune", "Renamed")
+	expect(t, events, "dune.Book.Renamed")
+	expect(t, events, "dune.Book.Archived")
+
+	if _, ok, _ := p.Position(""); ok {
+		t.Fatal("position of anonymous subscription is not expected")
+	}
+
+	f.add(5, "dune", "Restored")
+	s = es.NewPollingSubscriber(f, p, time.Millisecond).Gap(time.Millisecond)
+	defer s.Close()
+
+	if err := s.Subscribe(*es.NewSubscription(collect(events)).Name("archive").AggregateEvents("Book", "Restored")); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, events, "dune.Book.Restored")
+}
+
+func TestFeedOldGap(t *testing.T) {
+	f := &feed{}
+	events := make(chan es.AggregateEvents, 16)
+
+	s := es.NewPollingSubscriber(f, es.NewMemPositions(), time.Millisecond).Gap(time.Hour)
+	defer s.Close()
+
+	// gap followed by event stored hours ago is not filled anymore.
+	f.addAt(1, "dune", "Created", time.Now().Add(-2*time.Hour))
+	f.addAt(3, "dune", "Archived", time.Now().Add(-2*time.Hour))
+	if err := s.Subscribe(*es.NewSubscription(collect(events)).Name("books").AggregateEvents("*")); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, events, "dune.Book.Created")
+	expect(t, events, "dune.Book.Archived")
+}
+
+// feed of Book events, added in any order of positions.
+type feed struct {
+	mu     sync.Mutex
+	events []es.Event
+}
+
+func (f *feed) add(position uint64, id, event string) {
+	f.addAt(position, id, event, time.Now())
+}
+
+func (f *feed) addAt(position uint64, id, event string, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e := es.Event{Type: event, Position: position, Meta: []byte(id), CreatedAt: at}
+	for i := range f.events {
+		if f.events[i].Position > position {
+			f.events = append(f.events[:i], append([]es.Event{e}, f.events[i:]...)...)
+			return
+		}
+	}
+
+	f.events = append(f.events, e)
+}
+
+func (f *feed) After(position uint64, limit int) ([]es.AggregateEvents, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []es.AggregateEvents
+	for _, e := range f.events {
+		if e.Position > position && len(out) < limit {
+			out = append(out, es.AggregateEvents{Aggregate: es.Aggregate{ID: string(e.Meta), Type: "Book"}, Events: []es.Event{e}})
+		}
+	}
+
+	return out, nil
+}
+
+func (f *feed) Head() (uint64, error) { return 0, nil }
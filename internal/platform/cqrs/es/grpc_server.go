@@ -0,0 +1,264 @@
+package es
+
+//go:generate protoc -I espb --go_out=espb --go_opt=paths=source_relative --go-grpc_out=espb --go-grpc_opt=paths=source_relative event_store.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sokool/gokit/log"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es/espb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer exposes Storage and Subscriber as EventStore gRPC service, so one
+// process owns database and others use it through NewGRPCStore. Events
+// appended by clients are published by Publisher.
+type grpcServer struct {
+	espb.UnimplementedEventStoreServer
+
+	storage    Storage
+	publisher  Publisher
+	subscriber Subscriber
+	seq        uint64
+}
+
+// NewGRPCServer creates EventStore service, Publisher and Subscriber might be
+// nil, when appended events are not published, or subscriptions are not
+// served. Subscriber has to be able to Unsubscribe, so subscriptions of
+// disconnected clients are removed.
+func NewGRPCServer(s Storage, p Publisher, sub Subscriber) *grpcServer {
+	return &grpcServer{storage: s, publisher: p, subscriber: sub}
+}
+
+// Register EventStore service in gRPC server, which should be created with
+// GRPCRecovery options.
+func (g *grpcServer) Register(r grpc.ServiceRegistrar) {
+	espb.RegisterEventStoreServer(r, g)
+}
+
+func (g *grpcServer) Append(_ context.Context, r *espb.AppendRequest) (*espb.Stream, error) {
+	if r.GetAggregate().GetId() == "" || r.GetAggregate().GetType() == "" {
+		return nil, status.Error(codes.InvalidArgument, "no aggregate given")
+	}
+
+	a := AggregateEvents{Aggregate: fromPBAggregate(r.Aggregate)}
+	for _, e := range r.Events {
+		a.Events = append(a.Events, Event{Type: e.GetType(), Data: e.GetData(), Meta: e.GetMeta()})
+	}
+
+	if err := g.storage.Append(a, uint(r.ExpectedVersion)); err != nil {
+		return nil, grpcError(err)
+	}
+
+	// events are stored, client learns about it even when they are not
+	// published, like from Repository.
+	if g.publisher != nil {
+		if err := g.publisher.Publish(a); err != nil {
+			log.Error("es.grpc", fmt.Errorf("%s.%s stored, but not published: %s", a.ID, a.Type, err))
+		}
+	}
+
+	return toPBStream(a), nil
+}
+
+func (g *grpcServer) ReadStream(_ context.Context, r *espb.ReadStreamRequest) (*espb.Stream, error) {
+	if r.GetAggregate().GetId() == "" || r.GetAggregate().GetType() == "" {
+		return nil, status.Error(codes.InvalidArgument, "no aggregate given")
+	}
+
+	a, err := g.storage.FromVersion(fromPBAggregate(r.Aggregate), uint(r.FromVersion))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toPBStream(a), nil
+}
+
+func (g *grpcServer) ReadAggregate(_ context.Context, r *espb.ReadAggregateRequest) (*espb.Streams, error) {
+	if r.Type == "" {
+		return nil, status.Error(codes.InvalidArgument, "no aggregate given")
+	}
+
+	aa, err := g.storage.All(r.Type)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	s := &espb.Streams{}
+	for _, a := range aa {
+		s.Streams = append(s.Streams, toPBStream(a))
+	}
+
+	return s, nil
+}
+
+func (g *grpcServer) Copy(_ context.Context, r *espb.CopyRequest) (*espb.Empty, error) {
+	if err := g.storage.Copy(r.Type, r.Source, uint(r.FromVersion), r.Destination); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &espb.Empty{}, nil
+}
+
+// Subscribe streams events until client disconnects, subscription is
+// unsubscribed then. Subscription without name gets generated one. Delivery
+// is at most once, server does not learn whether client handled event, so
+// events which client failed to handle, or which were published while it was
+// disconnected, are not delivered again.
+func (g *grpcServer) Subscribe(r *espb.SubscribeRequest, stream espb.EventStore_SubscribeServer) error {
+	u, ok := g.subscriber.(interface{ Unsubscribe(string) error })
+	if !ok {
+		return status.Error(codes.Unimplemented, "subscriptions not served")
+	}
+
+	name := r.Name
+	if name == "" {
+		name = fmt.Sprintf("grpc.%d", atomic.AddUint64(&g.seq, 1))
+	}
+
+	if len(r.Filters) == 0 {
+		return status.Error(codes.InvalidArgument, "no aggregate given")
+	}
+
+	ctx := stream.Context()
+	var mu sync.Mutex
+	s := NewSubscription(func(a Aggregate, e Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s subscriber disconnected", name)
+		}
+
+		return stream.Send(&espb.Delivery{Aggregate: toPBAggregate(a), Event: toPBEvent(e)})
+	}).Name(name).Group(r.Group)
+
+	for _, f := range r.Filters {
+		s.AggregateEvents(f.Aggregate, f.Events...)
+	}
+
+	if err := g.subscriber.Subscribe(*s); err != nil {
+		return grpcError(err)
+	}
+
+	log.Debug("es.grpc", "%s subscribed", name)
+	<-ctx.Done()
+
+	if err := u.Unsubscribe(name); err != nil {
+		log.Error("es.grpc", err)
+	}
+
+	log.Debug("es.grpc", "%s unsubscribed", name)
+	return nil
+}
+
+// GRPCRecovery options of gRPC server turn panic of called method into
+// Internal error, instead of crashing whole server.
+func GRPCRecovery() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, r interface{}, i *grpc.UnaryServerInfo, h grpc.UnaryHandler) (_ interface{}, err error) {
+			defer recovery(i.FullMethod, &err)
+			return h(ctx, r)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, s grpc.ServerStream, i *grpc.StreamServerInfo, h grpc.StreamHandler) (err error) {
+			defer recovery(i.FullMethod, &err)
+			return h(srv, s)
+		}),
+	}
+}
+
+func recovery(method string, err *error) {
+	if r := recover(); r != nil {
+		log.Error("es.grpc", fmt.Errorf("%s panic: %v\n%s", method, r, debug.Stack()))
+		*err = status.Errorf(codes.Internal, "%s panic: %v", method, r)
+	}
+}
+
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	if errors.Is(err, ErrConcurrency) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toPBAggregate(a Aggregate) *espb.Aggregate {
+	return &espb.Aggregate{Id: a.ID, Type: a.Type}
+}
+
+func fromPBAggregate(a *espb.Aggregate) Aggregate {
+	return Aggregate{ID: a.GetId(), Type: a.GetType()}
+}
+
+func toPBStream(a AggregateEvents) *espb.Stream {
+	s := &espb.Stream{Aggregate: toPBAggregate(a.Aggregate)}
+	for _, e := range a.Events {
+		s.Events = append(s.Events, toPBEvent(e))
+	}
+
+	return s
+}
+
+// toPBEvent with creation time as RFC 3339 string, empty when not given.
+func toPBEvent(e Event) *espb.Event {
+	p := &espb.Event{
+		Type:     e.Type,
+		Version:  uint64(e.Version),
+		Data:     e.Data,
+		Meta:     e.Meta,
+		Position: e.Position,
+	}
+
+	if !e.CreatedAt.IsZero() {
+		p.CreatedAt = e.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	return p
+}
+
+func fromPBStream(s *espb.Stream) (AggregateEvents, error) {
+	a := AggregateEvents{Aggregate: fromPBAggregate(s.GetAggregate())}
+	for _, p := range s.GetEvents() {
+		e, err := fromPBEvent(p)
+		if err != nil {
+			return AggregateEvents{}, err
+		}
+
+		a.Events = append(a.Events, e)
+	}
+
+	return a, nil
+}
+
+func fromPBEvent(p *espb.Event) (Event, error) {
+	e := Event{
+		Type:     p.GetType(),
+		Version:  uint(p.GetVersion()),
+		Data:     p.GetData(),
+		Meta:     p.GetMeta(),
+		Position: p.GetPosition(),
+	}
+
+	if p.GetCreatedAt() == "" {
+		return e, nil
+	}
+
+	var err error
+	if e.CreatedAt, err = time.Parse(time.RFC3339Nano, p.GetCreatedAt()); err != nil {
+		return Event{}, fmt.Errorf("%s event created at: %w", e.Type, err)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,144 @@
+package es_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es/espb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCStore(t *testing.T) {
+	p := es.NewMemPubSub()
+	defer p.Close()
+
+	published := make(chan es.AggregateEvents, 16)
+	if err := p.Subscribe(*es.NewSubscription(collect(published)).Name("local").AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := grpcConn(t, es.NewGRPCServer(es.NewMemory(), p, p))
+	s := es.NewGRPCStore(c, time.Second)
+	defer s.Close()
+
+	remote := make(chan es.AggregateEvents, 16)
+	if err := s.Subscribe(*es.NewSubscription(collect(remote)).AggregateEvents("Book", "Renamed")); err != nil {
+		t.Fatal(err)
+	}
+
+	a := es.AggregateEvents{Aggregate: es.Aggregate{ID: "dune", Type: "Book"}, Events: []es.Event{{Type: "Created", Data: []byte(`{"title":"Dune"}`)}}}
+	if err := s.Append(a, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Events[0].Version != 1 {
+		t.Fatalf("version given by server expected, got %+v", a.Events[0])
+	}
+
+	expect(t, published, "dune.Book.Created")
+	if err := s.Append(a, 0); !errors.Is(err, es.ErrConcurrency) {
+		t.Fatalf("ErrConcurrency expected, got %v", err)
+	}
+
+	// subscription is made in background, events are appended until it
+	// delivers one.
+	for i := uint(1); ; i++ {
+		r := es.AggregateEvents{Aggregate: a.Aggregate, Events: []es.Event{{Type: "Renamed", Data: []byte(`{}`)}}}
+		if err := s.Append(r, i); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-remote:
+		case <-time.After(50 * time.Millisecond):
+			continue
+		}
+
+		break
+	}
+
+	l, err := s.FromVersion(a.Aggregate, 0)
+	if err != nil || len(l.Events) < 2 || string(l.Events[0].Data) != `{"title":"Dune"}` {
+		t.Fatalf("stored events expected, got %+v %v", l, err)
+	}
+
+	l, err = s.FromVersion(a.Aggregate, 2)
+	if err != nil || len(l.Events) == 0 || l.Events[0].Version != 2 || l.Events[0].Type != "Renamed" {
+		t.Fatalf("events from version 2 expected, got %+v %v", l, err)
+	}
+
+	aa, err := s.All("Book")
+	if err != nil || len(aa) != 1 || aa[0].ID != "dune" {
+		t.Fatalf("dune aggregate expected, got %+v %v", aa, err)
+	}
+
+	// EventStore shares server with other services.
+	h, err := grpc_health_v1.NewHealthClient(c).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil || h.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("serving health expected, got %v %v", h, err)
+	}
+}
+
+func TestGRPCStoreSubscriber(t *testing.T) {
+	c := grpcConn(t, es.NewGRPCServer(es.NewMemory(), nil, struct{ es.Subscriber }{es.NewMemPubSub()}))
+
+	r := &espb.SubscribeRequest{Filters: []*espb.SubscribeRequest_Filter{{Aggregate: "Book"}}}
+	s, err := espb.NewEventStoreClient(c).Subscribe(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Recv(); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("subscriber without Unsubscribe rejected expected, got %v", err)
+	}
+}
+
+func TestGRPCRecovery(t *testing.T) {
+	// storage without implementation panics in every call.
+	c := grpcConn(t, es.NewGRPCServer(struct{ es.Storage }{}, nil, nil))
+	s := es.NewGRPCStore(c, time.Second)
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.All("Book"); status.Code(err) != codes.Internal {
+			t.Fatalf("internal error expected, got %v", err)
+		}
+	}
+
+	a := es.Aggregate{Type: "Book"}
+	if _, err := s.FromVersion(a, 1); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid argument expected, got %v", err)
+	}
+}
+
+func grpcConn(t *testing.T, s interface{ Register(grpc.ServiceRegistrar) }) *grpc.ClientConn {
+	l := bufconn.Listen(1 << 20)
+	g := grpc.NewServer(es.GRPCRecovery()...)
+	s.Register(g)
+	grpc_health_v1.RegisterHealthServer(g, health.NewServer())
+
+	go g.Serve(l)
+	t.Cleanup(g.Stop)
+
+	c, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { c.Close() })
+	return c
+}
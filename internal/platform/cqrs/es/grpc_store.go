@@ -0,0 +1,227 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokool/gokit/log"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es/espb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcStore is Storage and Subscriber talking with EventStore service served
+// by NewGRPCServer.
+type grpcStore struct {
+	client  espb.EventStoreClient
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGRPCStore creates client of EventStore service, timeout limits every
+// unary call, zero means no limit.
+func NewGRPCStore(c grpc.ClientConnInterface, timeout time.Duration) *grpcStore {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &grpcStore{client: espb.NewEventStoreClient(c), timeout: timeout, ctx: ctx, cancel: cancel}
+}
+
+// Append events to aggregate, versions and creation time given by server are
+// set in AggregateEvents.
+func (g *grpcStore) Append(a AggregateEvents, expectedVersion uint) error {
+	r := &espb.AppendRequest{Aggregate: toPBAggregate(a.Aggregate), ExpectedVersion: uint64(expectedVersion)}
+	for _, e := range a.Events {
+		r.Events = append(r.Events, &espb.Event{Type: e.Type, Data: e.Data, Meta: e.Meta})
+	}
+
+	var p *espb.Stream
+	err := g.invoke(func(ctx context.Context) (err error) {
+		p, err = g.client.Append(ctx, r)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s, err := fromPBStream(p)
+	if err != nil {
+		return err
+	}
+
+	for i := range s.Events {
+		if i >= len(a.Events) {
+			break
+		}
+
+		a.Events[i].Version = s.Events[i].Version
+		a.Events[i].CreatedAt = s.Events[i].CreatedAt
+		a.Events[i].Position = s.Events[i].Position
+	}
+
+	return nil
+}
+
+func (g *grpcStore) FromVersion(a Aggregate, v uint) (AggregateEvents, error) {
+	r := &espb.ReadStreamRequest{Aggregate: toPBAggregate(a), FromVersion: uint64(v)}
+
+	var p *espb.Stream
+	err := g.invoke(func(ctx context.Context) (err error) {
+		p, err = g.client.ReadStream(ctx, r)
+		return err
+	})
+
+	if err != nil {
+		return AggregateEvents{}, err
+	}
+
+	return fromPBStream(p)
+}
+
+func (g *grpcStore) All(aggregate string) ([]AggregateEvents, error) {
+	var p *espb.Streams
+	err := g.invoke(func(ctx context.Context) (err error) {
+		p, err = g.client.ReadAggregate(ctx, &espb.ReadAggregateRequest{Type: aggregate})
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	aa := make([]AggregateEvents, 0, len(p.Streams))
+	for _, s := range p.Streams {
+		a, err := fromPBStream(s)
+		if err != nil {
+			return nil, err
+		}
+
+		aa = append(aa, a)
+	}
+
+	return aa, nil
+}
+
+func (g *grpcStore) Copy(aggregate, src string, from uint, dst string) error {
+	r := &espb.CopyRequest{
+		Type:        aggregate,
+		Source:      src,
+		FromVersion: uint64(from),
+		Destination: dst,
+	}
+
+	return g.invoke(func(ctx context.Context) error {
+		_, err := g.client.Copy(ctx, r)
+		return err
+	})
+}
+
+// Subscribe opens stream of events, which is opened again when broken, until
+// Close. Delivery is at most once, server does not know result of handler, so
+// error returned by it is only logged, and events published while stream is
+// broken are not delivered.
+func (g *grpcStore) Subscribe(s Subscription) error {
+	r := &espb.SubscribeRequest{Name: s.name, Group: s.group}
+	for a, ee := range s.subscriptions {
+		f := &espb.SubscribeRequest_Filter{Aggregate: a}
+		for e := range ee {
+			f.Events = append(f.Events, e)
+		}
+
+		r.Filters = append(r.Filters, f)
+	}
+
+	stream, err := g.client.Subscribe(g.ctx, r)
+	if err != nil {
+		return err
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		for attempt := 0; ; attempt++ {
+			if stream != nil {
+				attempt = 0
+				g.receive(s, stream)
+			}
+
+			select {
+			case <-g.ctx.Done():
+				return
+			case <-time.After(backoff(attempt)):
+			}
+
+			if stream, err = g.client.Subscribe(g.ctx, r); err != nil {
+				log.Error("es.grpc", fmt.Errorf("%s subscription: %w", s.name, err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops subscriptions.
+func (g *grpcStore) Close() error {
+	g.cancel()
+	g.wg.Wait()
+
+	return nil
+}
+
+func (g *grpcStore) receive(s Subscription, stream espb.EventStore_SubscribeClient) {
+	for {
+		d, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF && g.ctx.Err() == nil {
+				log.Error("es.grpc", fmt.Errorf("%s subscription: %w", s.name, err))
+			}
+
+			return
+		}
+
+		a := fromPBAggregate(d.Aggregate)
+		e, err := fromPBEvent(d.GetEvent())
+		if err == nil {
+			err = s.handler(a, e)
+		}
+
+		if err != nil {
+			log.Error("es.grpc", fmt.Errorf("%s.%s %s handler: %w", a.Type, a.ID, d.GetEvent().GetType(), err))
+		}
+	}
+}
+
+// invoke unary call limited by timeout, Aborted call is ErrConcurrency.
+func (g *grpcStore) invoke(call func(context.Context) error) error {
+	ctx := context.Background()
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+
+	err := call(ctx)
+	if status.Code(err) == codes.Aborted {
+		m := strings.TrimPrefix(status.Convert(err).Message(), ErrConcurrency.Error()+": ")
+		return fmt.Errorf("%w: %s", ErrConcurrency, m)
+	}
+
+	return err
+}
+
+// backoff of reopening broken stream, doubles up to 32s.
+func backoff(attempt int) time.Duration {
+	if attempt > 5 {
+		attempt = 5
+	}
+
+	return time.Second << attempt
+}
@@ -0,0 +1,65 @@
+package es
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type memDeadLetters struct {
+	mu      sync.Mutex
+	letters map[string]DeadLetter
+}
+
+func NewMemDeadLetters() DeadLetters {
+	return &memDeadLetters{letters: make(map[string]DeadLetter)}
+}
+
+func (m *memDeadLetters) Park(d DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p, ok := m.letters[d.ID]; ok {
+		d.Attempts += p.Attempts
+		d.CreatedAt = p.CreatedAt
+	}
+
+	m.letters[d.ID] = d
+	return nil
+}
+
+func (m *memDeadLetters) List(subscriber string) ([]DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []DeadLetter
+	for _, d := range m.letters {
+		if subscriber != "" && d.Subscriber != subscriber {
+			continue
+		}
+		out = append(out, d)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (m *memDeadLetters) Get(id string) (DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.letters[id]
+	if !ok {
+		return d, fmt.Errorf("dead letter %s not found", id)
+	}
+
+	return d, nil
+}
+
+func (m *memDeadLetters) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.letters, id)
+	return nil
+}
@@ -1,62 +1,461 @@
 package es
 
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sokool/gokit/log"
+)
+
+// Overflow tells what memory pubsub does with published events, when buffer
+// of subscription is full.
+type Overflow int
+
+const (
+	// Block waits until subscription handles buffered events. Events published
+	// by handlers are buffered without limit instead, since handler waiting
+	// for its own subscription, or for one waiting for it, would never return.
+	Block Overflow = iota
+	// DropNewest drops published events.
+	DropNewest
+	// DropOldest drops the oldest buffered events, to make room for published.
+	DropOldest
+	// Fail returns error from Publish.
+	Fail
+)
+
 type memPubSub struct {
-	subs2 []memSubscription
+	buffer      int
+	overflow    Overflow
+	synchronous bool
+
+	mu     sync.RWMutex
+	closed bool
+	subs   map[string]*memSubscription
+	seq    int
+	wg     sync.WaitGroup
 }
 
 type memSubscription struct {
 	name          string
-	handler       func(Aggregate, Event)
+	group         string
+	handler       func(Aggregate, Event) error
 	subscriptions map[string]map[string]bool
-	stream        chan AggregateEvents
+	queue         chan AggregateEvents
+	done          chan struct{}
+	wake          chan struct{}
+
+	// mu guards closing done against enqueuing, senders are Publish calls
+	// enqueuing events, which run loop waits for before it exits.
+	mu      sync.Mutex
+	closed  bool
+	senders sync.WaitGroup
+
+	// running tells that Publish call handles events in synchronous mode,
+	// events published meanwhile are added to backlog and handled by it. In
+	// asynchronous mode backlog keeps events published by handlers, in Block
+	// overflow, run loop handles them before buffered ones.
+	running bool
+	backlog []AggregateEvents
 }
 
-func (m *memSubscription) run() {
-	for a := range m.stream {
-		//go func(s memSubscription) {
-		subscription, ok := m.subscriptions[a.Type]
-		if !ok {
-			continue
-		}
+// NewMemPubSub delivers events to every subscription in order they were
+// published, through its own buffer.
+func NewMemPubSub(oo ...MemOption) *memPubSub {
+	m := &memPubSub{
+		buffer: 64,
+		subs:   make(map[string]*memSubscription),
+	}
+
+	for _, o := range oo {
+		o(m)
+	}
+
+	return m
+}
 
-		//all
-		if len(subscription) == 0 {
+// Publish puts events into buffers of subscriptions. In synchronous mode it
+// calls handlers instead, returning first of their errors. Handler of
+// subscription is never called concurrently, events published while it
+// handles others (also by handler itself) are handled after them, by Publish
+// call which already handles, so their errors are returned by that call.
+func (p *memPubSub) Publish(a AggregateEvents) error {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return fmt.Errorf("memory pubsub closed")
+	}
 
+	var ss []*memSubscription
+	groups := make(map[string][]*memSubscription)
+	for _, s := range p.subs {
+		if s.group == "" {
+			ss = append(ss, s)
+			continue
 		}
+		groups[s.group] = append(groups[s.group], s)
+	}
+	p.mu.RUnlock()
+
+	// member of group is chosen by aggregate ID, members are sorted, so
+	// all of them are partitioned the same way until member joins or leaves.
+	for _, mm := range groups {
+		sort.Slice(mm, func(i, j int) bool { return mm[i].name < mm[j].name })
+		ss = append(ss, mm[partition(a.ID, len(mm))])
+	}
 
-		for _, event := range a.Events {
-			if !subscription[event.Type] {
-				continue
+	var err error
+	for _, s := range ss {
+		if p.synchronous {
+			if herr := s.handleNow(a); herr != nil && err == nil {
+				err = herr
 			}
+			continue
+		}
 
-			m.handler(a.Aggregate, event)
+		if qerr := p.enqueue(s, a); qerr != nil && err == nil {
+			err = qerr
 		}
 	}
 
+	return err
+}
+
+// Subscribe starts delivering events to Subscription. Subscription without
+// events receives all events of given aggregate, "*" aggregate receives
+// events of all aggregates. Subscription with name already in use replaces
+// previous one, subscriptions without name never replace each other. Members
+// of Subscription group, which need names, are rebalanced when one of them
+// subscribes or unsubscribes, events buffered by member before rebalancing are
+// still delivered by it.
+func (p *memPubSub) Subscribe(s Subscription) error {
+	z := &memSubscription{
+		name:          s.name,
+		group:         s.group,
+		handler:       s.handler,
+		subscriptions: s.subscriptions,
+		queue:         make(chan AggregateEvents, p.buffer),
+		done:          make(chan struct{}),
+		wake:          make(chan struct{}, 1),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("memory pubsub closed")
+	}
+
+	key := s.name
+	if key == "" {
+		p.seq++
+		key = fmt.Sprintf("#%d", p.seq)
+	}
+
+	if o, ok := p.subs[key]; ok {
+		o.close()
+	}
+
+	p.subs[key] = z
+	if !p.synchronous {
+		p.wg.Add(1)
+		go z.run(&p.wg)
+	}
+
+	return nil
 }
-func NewMemPubSub() *memPubSub {
-	return &memPubSub{}
+
+// Unsubscribe stops delivering events to Subscription of given name, events
+// already buffered are still delivered.
+func (p *memPubSub) Unsubscribe(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.subs[name]
+	if !ok {
+		return fmt.Errorf("%s subscription not found", name)
+	}
+
+	s.close()
+	delete(p.subs, name)
+
+	return nil
 }
 
-func (p *memPubSub) Publish(a AggregateEvents) error {
-	for _, s := range p.subs2 {
-		go func(s memSubscription) { s.stream <- a }(s)
+// Close unsubscribes all subscriptions and waits until buffered events are
+// delivered.
+func (p *memPubSub) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for n, s := range p.subs {
+			s.close()
+			delete(p.subs, n)
+		}
 	}
+	p.mu.Unlock()
 
+	p.wg.Wait()
 	return nil
 }
 
-func (p *memPubSub) Subscribe(s Subscription) error {
-	z := memSubscription{
-		name:          s.name,
-		handler:       s.handler,
-		subscriptions: s.subscriptions,
-		stream:        make(chan AggregateEvents),
+func (p *memPubSub) enqueue(s *memSubscription, a AggregateEvents) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return p.closedErr()
 	}
+	s.senders.Add(1)
+	s.mu.Unlock()
+	defer s.senders.Done()
+
+	switch p.overflow {
+	case DropNewest:
+		select {
+		case s.queue <- a:
+		default:
+			log.Debug("es.memory", "%s buffer is full, %s.%s dropped", s.name, a.ID, a.Type)
+		}
+
+	case DropOldest:
+		for {
+			select {
+			case s.queue <- a:
+				return nil
+			default:
+			}
+
+			select {
+			case o := <-s.queue:
+				log.Debug("es.memory", "%s buffer is full, %s.%s dropped", s.name, o.ID, o.Type)
+			default:
+			}
+		}
+
+	case Fail:
+		select {
+		case s.queue <- a:
+		default:
+			return fmt.Errorf("%s buffer is full", s.name)
+		}
+
+	default:
+		if handler() {
+			s.push(a)
+			break
+		}
+
+		// run loop handles events until enqueuing ones are done, even when
+		// subscription is closed meanwhile.
+		s.queue <- a
+	}
+
+	return nil
+}
 
+// closedErr tells whether Publish failed to enqueue events to subscription,
+// which was closed, because pubsub was closed meanwhile.
+func (p *memPubSub) closedErr() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
-	go z.run()
+	if p.closed {
+		return fmt.Errorf("memory pubsub closed")
+	}
 
-	p.subs2 = append(p.subs2, z)
 	return nil
 }
+
+// handler tells whether caller is handler of subscription, called by its run
+// loop, which is at the bottom of whole stack of caller.
+func handler() bool {
+	pc := make([]uintptr, 64)
+	n := runtime.Callers(3, pc)
+	for ; n == len(pc); n = runtime.Callers(3, pc) {
+		pc = make([]uintptr, 2*len(pc))
+	}
+
+	ff := runtime.CallersFrames(pc[:n])
+	for {
+		f, more := ff.Next()
+		if strings.HasSuffix(f.Function, ".(*memSubscription).run") {
+			return true
+		}
+
+		if !more {
+			return false
+		}
+	}
+}
+
+// push events to backlog and wake up run loop.
+func (m *memSubscription) push(a AggregateEvents) {
+	m.mu.Lock()
+	m.backlog = append(m.backlog, a)
+	m.mu.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop events from backlog.
+func (m *memSubscription) pop() (AggregateEvents, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.backlog) == 0 {
+		return AggregateEvents{}, false
+	}
+
+	a := m.backlog[0]
+	m.backlog = m.backlog[1:]
+	return a, true
+}
+
+// close stops enqueuing events to subscription.
+func (m *memSubscription) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+}
+
+// run handles enqueued events until subscription is closed, then it handles
+// events enqueued by Publish calls which were in progress. Events published by
+// handlers are handled first.
+func (m *memSubscription) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		if a, ok := m.pop(); ok {
+			m.log(m.handle(a))
+			continue
+		}
+
+		select {
+		case a := <-m.queue:
+			m.log(m.handle(a))
+
+		case <-m.wake:
+
+		case <-m.done:
+			idle := make(chan struct{})
+			go func() { m.senders.Wait(); close(idle) }()
+
+			for {
+				if a, ok := m.pop(); ok {
+					m.log(m.handle(a))
+					continue
+				}
+
+				select {
+				case a := <-m.queue:
+					m.log(m.handle(a))
+				case <-m.wake:
+				case <-idle:
+					for {
+						if a, ok := m.pop(); ok {
+							m.log(m.handle(a))
+							continue
+						}
+
+						select {
+						case a := <-m.queue:
+							m.log(m.handle(a))
+						default:
+							return
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// handleNow handles events in synchronous mode, one Publish call at a time.
+func (m *memSubscription) handleNow(a AggregateEvents) error {
+	m.mu.Lock()
+	m.backlog = append(m.backlog, a)
+	if m.running {
+		m.mu.Unlock()
+		return nil
+	}
+	m.running = true
+
+	var err error
+	for len(m.backlog) > 0 {
+		a := m.backlog[0]
+		m.backlog = m.backlog[1:]
+		m.mu.Unlock()
+
+		if herr := m.handle(a); herr != nil && err == nil {
+			err = herr
+		}
+
+		m.mu.Lock()
+	}
+
+	m.running = false
+	m.mu.Unlock()
+
+	return err
+}
+
+// handle delivers subscribed events, returning first handler error.
+func (m *memSubscription) handle(a AggregateEvents) error {
+	subscription, ok := m.subscriptions[a.Type]
+	if !ok {
+		if subscription, ok = m.subscriptions["*"]; !ok {
+			return nil
+		}
+	}
+
+	var err error
+	for _, event := range a.Events {
+		if len(subscription) > 0 && !subscription[event.Type] {
+			continue
+		}
+
+		if herr := m.handler(a.Aggregate, event); herr != nil {
+			herr = fmt.Errorf("%s %s.%s.%s[v.%d] %s", m.name, a.ID, a.Type, event.Type, event.Version, herr)
+			if err == nil {
+				err = herr
+			}
+		}
+	}
+
+	return err
+}
+
+func (m *memSubscription) log(err error) {
+	if err != nil {
+		log.Error("es.memory", err)
+	}
+}
+
+type MemOption func(*memPubSub)
+
+// MemBuffer sets number of published events batches buffered by every
+// subscription, default is 64.
+func MemBuffer(n int) MemOption {
+	return func(p *memPubSub) { p.buffer = n }
+}
+
+// MemOverflow sets what happens when buffer of subscription is full, default
+// is Block.
+func MemOverflow(o Overflow) MemOption {
+	return func(p *memPubSub) { p.overflow = o }
+}
+
+// MemSynchronous delivers events within Publish call, useful in tests.
+func MemSynchronous() MemOption {
+	return func(p *memPubSub) { p.synchronous = true }
+}
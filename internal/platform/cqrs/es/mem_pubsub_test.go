@@ -0,0 +1,283 @@
+package es_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestMemPubSubAnonymous(t *testing.T) {
+	p := es.NewMemPubSub(es.MemSynchronous())
+	first, second := make(chan es.AggregateEvents, 16), make(chan es.AggregateEvents, 16)
+
+	for _, c := range []chan es.AggregateEvents{first, second} {
+		if err := p.Subscribe(*es.NewSubscription(collect(c)).AggregateEvents("Book")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish(t, p, "dune", "Book", "Created")
+	expect(t, first, "dune.Book.Created")
+	expect(t, second, "dune.Book.Created")
+}
+
+func TestMemPubSubSynchronous(t *testing.T) {
+	p := es.NewMemPubSub(es.MemSynchronous())
+
+	var running, overlaps, handled int32
+	s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		defer atomic.AddInt32(&running, -1)
+
+		atomic.AddInt32(&handled, 1)
+		// event published by handler is handled after this one
+		if e.Type == "Created" {
+			return p.Publish(bookEvents(a.ID, "Renamed"))
+		}
+
+		return nil
+	})
+
+	if err := p.Subscribe(*s.AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := p.Publish(bookEvents(fmt.Sprint(i), "Created")); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	if overlaps != 0 || handled != 100 {
+		t.Fatalf("100 events handled one by one expected, got %d with %d overlaps", handled, overlaps)
+	}
+
+	failure := fmt.Errorf("database is down")
+	p = es.NewMemPubSub(es.MemSynchronous())
+	if err := p.Subscribe(*es.NewSubscription(func(es.Aggregate, es.Event) error { return failure }).AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Publish(bookEvents("dune", "Created")); err == nil {
+		t.Fatal("handler error expected")
+	}
+}
+
+func TestMemPubSubClose(t *testing.T) {
+	cases := []struct {
+		desc     string
+		overflow es.Overflow
+	}{
+		{"block", es.Block},
+		{"drop oldest", es.DropOldest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			p := es.NewMemPubSub(es.MemBuffer(1), es.MemOverflow(c.overflow))
+
+			var handled int32
+			s := es.NewSubscription(func(es.Aggregate, es.Event) error { atomic.AddInt32(&handled, 1); return nil })
+			if err := p.Subscribe(*s.Name("books").AggregateEvents("Book")); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 100; i++ {
+				publish(t, p, fmt.Sprint(i), "Book", "Created")
+			}
+
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if c.overflow == es.Block && handled != 100 {
+				t.Fatalf("100 handled events expected, got %d", handled)
+			}
+
+			if handled == 0 {
+				t.Fatal("handled events expected")
+			}
+
+			if err := p.Publish(es.AggregateEvents{}); err == nil {
+				t.Fatal("closed pubsub error expected")
+			}
+		})
+	}
+}
+
+func TestMemPubSubHandlerPublishing(t *testing.T) {
+	p := es.NewMemPubSub(es.MemBuffer(1))
+	defer p.Close()
+
+	events := make(chan es.AggregateEvents, 16)
+	s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+		collect(events)(a, e)
+		if e.Type != "Created" {
+			return nil
+		}
+
+		// buffer of subscription is full, while it handles this event.
+		for _, n := range []string{"Renamed", "Lent", "Returned"} {
+			if err := p.Publish(bookEvents(a.ID, n)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err := p.Subscribe(*s.AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, p, "dune", "Book", "Created")
+	for _, e := range []string{"Created", "Renamed", "Lent", "Returned"} {
+		expect(t, events, "dune.Book."+e)
+	}
+}
+
+func TestMemPubSubPublishAfterClose(t *testing.T) {
+	p := es.NewMemPubSub()
+	closed := make(chan error, 1)
+	s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+		if e.Type == "Created" {
+			// handler publishes while pubsub is closed.
+			for p.Publish(bookEvents(a.ID, "Renamed")) == nil {
+			}
+			closed <- p.Publish(bookEvents(a.ID, "Renamed"))
+		}
+
+		return nil
+	})
+
+	if err := p.Subscribe(*s.AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, p, "dune", "Book", "Created")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-closed; err == nil {
+		t.Fatal("closed pubsub error expected")
+	}
+}
+
+func TestMemPubSubAllAggregates(t *testing.T) {
+	cases := []struct {
+		desc      string
+		aggregate string
+		events    []string
+		expects   []string
+	}{
+		{"all events", "*", nil, []string{"dune.Book.Created", "herbert.Author.Created", "dune.Book.Renamed"}},
+		{"chosen events", "*", []string{"Renamed"}, []string{"dune.Book.Renamed"}},
+		{"one aggregate", "Author", nil, []string{"herbert.Author.Created"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			p := es.NewMemPubSub(es.MemSynchronous())
+			events := make(chan es.AggregateEvents, 16)
+			if err := p.Subscribe(*es.NewSubscription(collect(events)).AggregateEvents(c.aggregate, c.events...)); err != nil {
+				t.Fatal(err)
+			}
+
+			publish(t, p, "dune", "Book", "Created")
+			publish(t, p, "herbert", "Author", "Created")
+			p.Publish(es.AggregateEvents{Aggregate: es.Aggregate{ID: "dune", Type: "Book"}, Events: []es.Event{{Type: "Renamed", Version: 2}}})
+
+			for _, e := range c.expects {
+				expect(t, events, e)
+			}
+
+			if len(events) != 0 {
+				t.Fatalf("%d more events not expected", len(events))
+			}
+		})
+	}
+}
+
+func TestMemPubSubGroup(t *testing.T) {
+	p := es.NewMemPubSub(es.MemSynchronous())
+	handled := make(map[string]map[string]int)
+
+	member := func(name string) {
+		t.Helper()
+		handled[name] = make(map[string]int)
+		s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+			handled[name][a.ID]++
+			return nil
+		})
+
+		if err := p.Subscribe(*s.Name(name).Group("books").AggregateEvents("Book")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every aggregate is handled by one member, members share aggregates.
+	check := func(members ...string) {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			publish(t, p, fmt.Sprint(i), "Book", "Created", "Renamed")
+		}
+
+		for i := 0; i < 50; i++ {
+			var by []string
+			for _, m := range members {
+				if n := handled[m][fmt.Sprint(i)]; n == 2 {
+					by = append(by, m)
+				} else if n != 0 {
+					t.Fatalf("%d aggregate events of %d handled by %s", n, i, m)
+				}
+			}
+
+			if len(by) != 1 {
+				t.Fatalf("aggregate %d handled by one member expected, got %v", i, by)
+			}
+		}
+
+		for _, m := range members {
+			if len(handled[m]) == 0 {
+				t.Fatalf("%s handling aggregates expected", m)
+			}
+			handled[m] = make(map[string]int)
+		}
+	}
+
+	member("first")
+	member("second")
+	check("first", "second")
+
+	if err := p.Unsubscribe("second"); err != nil {
+		t.Fatal(err)
+	}
+
+	check("first")
+
+	member("third")
+	member("second")
+	check("first", "second", "third")
+}
+
+// bookEvents of Book aggregate.
+func bookEvents(id string, events ...string) es.AggregateEvents {
+	a := es.AggregateEvents{Aggregate: es.Aggregate{ID: id, Type: "Book"}}
+	for _, e := range events {
+		a.Events = append(a.Events, es.Event{Type: e})
+	}
+
+	return a
+}
@@ -1,12 +1,20 @@
 package es
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
 
 type memStore struct {
+	mu     sync.RWMutex
 	events map[string]AggregateEvents
 }
 
 func (m *memStore) Copy(aggregate, src string, after uint, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	source, ok := m.events[src+aggregate]
 	if !ok {
 		return fmt.Errorf("%s:%s source not found", aggregate, src)
@@ -25,44 +33,82 @@ func (m *memStore) Copy(aggregate, src string, after uint, dst string) error {
 		a.Events = append(a.Events, source.Events[i])
 	}
 
-	return m.Append(a, 0)
+	return m.append(a, m.version(dst+aggregate))
 }
 
 func NewMemory() Storage {
 	return &memStore{events: make(map[string]AggregateEvents)}
 }
 
-func (m *memStore) Append(ae AggregateEvents, v uint) error {
-	a, ok := m.events[ae.ID+ae.Type]
-	if ok {
-		v := a.Events[len(a.Events)-1].Version
-		for i := range ae.Events {
-			v++
-			ae.Events[i].Version = v
-			a.Events = append(a.Events, ae.Events[i])
+func (m *memStore) Append(ae AggregateEvents, expected uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.append(ae, expected)
+}
+
+func (m *memStore) FromVersion(a Aggregate, v uint) (AggregateEvents, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := m.events[a.ID+a.Type]
+	r := AggregateEvents{Aggregate: s.Aggregate}
+	for _, e := range s.Events {
+		if e.Version >= v {
+			r.Events = append(r.Events, e)
 		}
+	}
+
+	return r, nil
+}
 
-		m.events[ae.ID+ae.Type] = a
+// All returns events of every aggregate of given type, sorted by aggregate ID.
+func (m *memStore) All(aggregate string) ([]AggregateEvents, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-		return nil
+	var aa []AggregateEvents
+	for _, a := range m.events {
+		if a.Type == aggregate {
+			aa = append(aa, AggregateEvents{Aggregate: a.Aggregate, Events: append([]Event(nil), a.Events...)})
+		}
 	}
 
+	sort.Slice(aa, func(i, j int) bool { return aa[i].ID < aa[j].ID })
+	return aa, nil
+}
+
+// append events numbered after expected version, stored aggregate keeps its
+// own copy of them.
+func (m *memStore) append(ae AggregateEvents, expected uint) error {
+	key := ae.ID + ae.Type
+	if m.version(key) != expected {
+		return conflict(ae.Aggregate, expected)
+	}
+
+	a, ok := m.events[key]
+	if !ok {
+		a = AggregateEvents{Aggregate: ae.Aggregate}
+	}
+
+	v := expected
 	for i := range ae.Events {
-		ae.Events[i].Version = uint(i + 1)
+		v++
+		ae.Events[i].Version = v
+		a.Events = append(a.Events, ae.Events[i])
 	}
-	m.events[ae.ID+ae.Type] = ae
+
+	m.events[key] = a
 
 	return nil
 }
 
-func (m *memStore) FromVersion(a Aggregate, v uint) (AggregateEvents, error) {
-	if v == 0 {
-		return m.events[a.ID+a.Type], nil
+// version of stored aggregate, zero when it has no events.
+func (m *memStore) version(key string) uint {
+	a := m.events[key]
+	if n := len(a.Events); n > 0 {
+		return a.Events[n-1].Version
 	}
 
-	panic("implement me")
-}
-
-func (m *memStore) All(aggregate string) ([]AggregateEvents, error) {
-	panic("implement me")
+	return 0
 }
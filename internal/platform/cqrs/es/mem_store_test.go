@@ -0,0 +1,66 @@
+package es_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestMemoryCopy(t *testing.T) {
+	m := es.NewMemory()
+	for _, a := range []es.AggregateEvents{bookEvents("dune", "Created", "Renamed"), bookEvents("copy", "Created")} {
+		if err := m.Append(a, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Copy("Book", "dune", 2, "copy"); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := m.FromVersion(es.Aggregate{ID: "copy", Type: "Book"}, 0)
+	if err != nil || len(a.Events) != 2 || a.Events[1].Type != "Renamed" || a.Events[1].Version != 2 {
+		t.Fatalf("copied event in version 2 expected, got %+v %v", a, err)
+	}
+}
+
+func TestMemoryAppend(t *testing.T) {
+	m := es.NewMemory()
+	if err := m.Append(bookEvents("dune"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// aggregate stored without events is in version 0.
+	if err := m.Append(bookEvents("dune", "Created"), 1); err == nil {
+		t.Fatal("conflict expected")
+	}
+
+	if err := m.Append(bookEvents("dune", "Created"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	conflicts := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Append(bookEvents("dune", "Renamed"), 1); err != nil {
+				conflicts <- err
+			}
+			m.FromVersion(es.Aggregate{ID: "dune", Type: "Book"}, 1)
+			m.All("Book")
+		}()
+	}
+
+	wg.Wait()
+	if len(conflicts) != 19 {
+		t.Fatalf("one of concurrent appends in version 1 expected, got %d conflicts", len(conflicts))
+	}
+
+	a, err := m.FromVersion(es.Aggregate{ID: "dune", Type: "Book"}, 2)
+	if err != nil || len(a.Events) != 1 || a.Events[0].Version != 2 {
+		t.Fatalf("renamed event in version 2 expected, got %+v %v", a, err)
+	}
+}
@@ -0,0 +1,165 @@
+package es
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type MySQLDeadLetters struct {
+	db *sql.DB
+}
+
+func NewMySQLDeadLetters(c *sql.DB) *MySQLDeadLetters {
+	return &MySQLDeadLetters{
+		db: c,
+	}
+}
+
+func (s *MySQLDeadLetters) Park(d DeadLetter) error {
+	stmt, err := s.db.Prepare(insertDeadLetter)
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(
+		d.ID,
+		d.Subscriber,
+		d.Aggregate.ID,
+		d.Aggregate.Type,
+		d.Event.Type,
+		d.Event.Version,
+		d.Event.CreatedAt.Format("2006-01-02 15:04:05"),
+		d.Event.Data,
+		d.Event.Meta,
+		d.Error,
+		d.Attempts,
+		d.CreatedAt.Format("2006-01-02 15:04:05"),
+		d.UpdatedAt.Format("2006-01-02 15:04:05"))
+
+	return err
+}
+
+func (s *MySQLDeadLetters) List(subscriber string) ([]DeadLetter, error) {
+	q := selectDeadLetters + " ORDER BY created_at ASC"
+	var args []interface{}
+	if subscriber != "" {
+		q = selectDeadLetters + " WHERE subscriber = ? ORDER BY created_at ASC"
+		args = append(args, subscriber)
+	}
+
+	r, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	var out []DeadLetter
+	for r.Next() {
+		d, err := s.scan(r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *MySQLDeadLetters) Get(id string) (DeadLetter, error) {
+	d, err := s.scan(s.db.QueryRow(selectDeadLetters+" WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return d, fmt.Errorf("dead letter %s not found", id)
+	}
+
+	return d, err
+}
+
+func (s *MySQLDeadLetters) Delete(id string) error {
+	stmt, err := s.db.Prepare("DELETE FROM cqrs_dead_letters WHERE id = ?")
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+	_, err = stmt.Exec(id)
+
+	return err
+}
+
+func (s *MySQLDeadLetters) Create(overwrite ...bool) error {
+	if len(overwrite) == 1 && overwrite[0] {
+		if _, err := s.db.Exec("DROP TABLE IF EXISTS cqrs_dead_letters;"); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(createDeadLettersTable)
+	return err
+}
+
+func (s *MySQLDeadLetters) scan(r interface{ Scan(...interface{}) error }) (DeadLetter, error) {
+	var d DeadLetter
+	var created, updated, occurred string
+	err := r.Scan(
+		&d.ID,
+		&d.Subscriber,
+		&d.Aggregate.ID,
+		&d.Aggregate.Type,
+		&d.Event.Type,
+		&d.Event.Version,
+		&occurred,
+		&d.Event.Data,
+		&d.Event.Meta,
+		&d.Error,
+		&d.Attempts,
+		&created,
+		&updated)
+
+	if err != nil {
+		return d, err
+	}
+
+	d.Event.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", occurred)
+	d.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", created)
+	d.UpdatedAt, _ = time.Parse("2006-01-02 15:04:05", updated)
+
+	return d, nil
+}
+
+const insertDeadLetter = `INSERT INTO
+	cqrs_dead_letters(id, subscriber, aggregate_id, aggregate_name, name, sequence, occurred_at, payload, meta, error, attempts, created_at, updated_at)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE
+		error = VALUES(error),
+		attempts = attempts + VALUES(attempts),
+		updated_at = VALUES(updated_at)`
+
+const selectDeadLetters = `SELECT
+	id, subscriber, aggregate_id, aggregate_name, name, sequence, occurred_at, payload, meta, error, attempts, created_at, updated_at
+	FROM cqrs_dead_letters`
+
+const createDeadLettersTable = `CREATE TABLE IF NOT EXISTS cqrs_dead_letters (
+  id varchar(255) NOT NULL,
+  subscriber varchar(255) NOT NULL,
+  aggregate_id varchar(255) NOT NULL,
+  aggregate_name varchar(255) NOT NULL,
+  name varchar(255) NOT NULL,
+  sequence int(11) NOT NULL,
+  occurred_at varchar(255) NOT NULL,
+  payload TEXT NOT NULL,
+  meta text,
+  error text NOT NULL,
+  attempts int(11) NOT NULL,
+  created_at varchar(255) NOT NULL,
+  updated_at varchar(255) NOT NULL,
+  PRIMARY KEY (id),
+  KEY subscriber (subscriber)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`
@@ -0,0 +1,158 @@
+package es
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type MySQLSagas struct {
+	db *sql.DB
+}
+
+func NewMySQLSagas(c *sql.DB) *MySQLSagas {
+	return &MySQLSagas{
+		db: c,
+	}
+}
+
+func (s *MySQLSagas) Load(saga, id string) (SagaState, bool, error) {
+	st, err := s.scan(s.db.QueryRow(selectSagas+" WHERE saga = ? AND id = ?", saga, id))
+	if err == sql.ErrNoRows {
+		return st, false, nil
+	}
+
+	if err != nil {
+		return st, false, err
+	}
+
+	return st, true, nil
+}
+
+func (s *MySQLSagas) Save(st SagaState, expected uint) error {
+	pending, err := json.Marshal(st.Pending)
+	if err != nil {
+		return err
+	}
+
+	processed, err := json.Marshal(st.Processed)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	if expected == 0 {
+		_, err := s.db.Exec(`INSERT INTO
+			cqrs_sagas(saga, id, version, data, pending, processed, done, updated_at)
+			VALUES(?, ?, 1, ?, ?, ?, ?, ?)`,
+			st.Saga, st.ID, st.Data, pending, processed, st.Done, now)
+
+		if err, ok := err.(*mysql.MySQLError); ok && err.Number == 1062 {
+			return fmt.Errorf("%w: %s.%s saga already exists", ErrConcurrency, st.Saga, st.ID)
+		}
+
+		return err
+	}
+
+	r, err := s.db.Exec(`UPDATE cqrs_sagas
+		SET version = version + 1, data = ?, pending = ?, processed = ?, done = ?, updated_at = ?
+		WHERE saga = ? AND id = ? AND version = ?`,
+		st.Data, pending, processed, st.Done, now, st.Saga, st.ID, expected)
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %s.%s saga is not in version %d", ErrConcurrency, st.Saga, st.ID, expected)
+	}
+
+	return nil
+}
+
+func (s *MySQLSagas) Pending(saga string) ([]SagaState, error) {
+	r, err := s.db.Query(selectSagas+" WHERE saga = ? AND pending <> '[]' AND pending <> 'null' ORDER BY updated_at ASC", saga)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	var out []SagaState
+	for r.Next() {
+		st, err := s.scan(r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *MySQLSagas) Create(overwrite ...bool) error {
+	if len(overwrite) == 1 && overwrite[0] {
+		if _, err := s.db.Exec("DROP TABLE IF EXISTS cqrs_sagas;"); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(createSagasTable)
+	return err
+}
+
+func (s *MySQLSagas) scan(r interface{ Scan(...interface{}) error }) (SagaState, error) {
+	var st SagaState
+	var pending, processed []byte
+	var updated string
+	err := r.Scan(
+		&st.Saga,
+		&st.ID,
+		&st.Version,
+		&st.Data,
+		&pending,
+		&processed,
+		&st.Done,
+		&updated)
+
+	if err != nil {
+		return st, err
+	}
+
+	if err := json.Unmarshal(pending, &st.Pending); err != nil {
+		return st, fmt.Errorf("%s.%s saga pending commands: %s", st.Saga, st.ID, err)
+	}
+
+	if err := json.Unmarshal(processed, &st.Processed); err != nil {
+		return st, fmt.Errorf("%s.%s saga processed events: %s", st.Saga, st.ID, err)
+	}
+
+	st.UpdatedAt, _ = time.Parse("2006-01-02 15:04:05", updated)
+
+	return st, nil
+}
+
+const selectSagas = `SELECT
+	saga, id, version, data, pending, processed, done, updated_at
+	FROM cqrs_sagas`
+
+const createSagasTable = `CREATE TABLE IF NOT EXISTS cqrs_sagas (
+  saga varchar(191) NOT NULL,
+  id varchar(191) NOT NULL,
+  version int(11) NOT NULL,
+  data mediumtext NOT NULL,
+  pending mediumtext NOT NULL,
+  processed mediumtext NOT NULL,
+  done tinyint(1) NOT NULL,
+  updated_at varchar(255) NOT NULL,
+  PRIMARY KEY (saga, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`
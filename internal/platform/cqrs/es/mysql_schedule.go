@@ -0,0 +1,162 @@
+package es
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MySQLSchedule keeps times with microseconds in UTC, so Done and Retry
+// recognize command which was not replaced. Due leases every listed command
+// with conditional update, command leased by other Scheduler is skipped.
+type MySQLSchedule struct {
+	db *sql.DB
+}
+
+func NewMySQLSchedule(c *sql.DB) *MySQLSchedule {
+	return &MySQLSchedule{
+		db: c,
+	}
+}
+
+func (s *MySQLSchedule) Schedule(c Scheduled) error {
+	_, err := s.db.Exec(`INSERT INTO
+		cqrs_schedule(schedule_key, aggregate_id, command, payload, meta, at, attempts, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			aggregate_id = VALUES(aggregate_id),
+			command = VALUES(command),
+			payload = VALUES(payload),
+			meta = VALUES(meta),
+			at = VALUES(at),
+			attempts = VALUES(attempts),
+			created_at = VALUES(created_at)`,
+		c.Key, c.Aggregate, c.Command, c.Data, c.Meta, timestamp(c.At), c.Attempts, timestamp(c.CreatedAt))
+
+	return err
+}
+
+func (s *MySQLSchedule) Cancel(key string) error {
+	_, err := s.db.Exec("DELETE FROM cqrs_schedule WHERE schedule_key = ?", key)
+	return err
+}
+
+func (s *MySQLSchedule) Due(now time.Time, lease time.Duration, limit int) ([]Scheduled, error) {
+	r, err := s.db.Query(`SELECT
+		schedule_key, aggregate_id, command, payload, meta, at, attempts, created_at
+		FROM cqrs_schedule
+		WHERE at <= ?
+		ORDER BY at ASC
+		LIMIT ?`, timestamp(now), limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	var out []Scheduled
+	for r.Next() {
+		var c Scheduled
+		var at, created string
+		if err := r.Scan(&c.Key, &c.Aggregate, &c.Command, &c.Data, &c.Meta, &at, &c.Attempts, &created); err != nil {
+			return nil, err
+		}
+
+		c.At, _ = time.Parse(timestampFormat, at)
+		c.CreatedAt, _ = time.Parse(timestampFormat, created)
+		out = append(out, c)
+	}
+
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
+
+	r.Close()
+	at, _ := time.Parse(timestampFormat, timestamp(now.Add(lease)))
+
+	var leased []Scheduled
+	for _, c := range out {
+		ok, err := s.move(c, at, c.Attempts)
+		if err != nil {
+			return leased, err
+		}
+
+		if ok {
+			c.At = at
+			leased = append(leased, c)
+		}
+	}
+
+	return leased, nil
+}
+
+func (s *MySQLSchedule) Done(c Scheduled) error {
+	_, err := s.db.Exec("DELETE FROM cqrs_schedule WHERE schedule_key = ? AND at = ? AND created_at = ?",
+		c.Key, timestamp(c.At), timestamp(c.CreatedAt))
+
+	return err
+}
+
+func (s *MySQLSchedule) Retry(c Scheduled, at time.Time) error {
+	_, err := s.move(c, at, c.Attempts)
+	return err
+}
+
+// move command to given time, when it was not changed since it was read.
+func (s *MySQLSchedule) move(c Scheduled, at time.Time, attempts uint) (bool, error) {
+	r, err := s.db.Exec("UPDATE cqrs_schedule SET at = ?, attempts = ? WHERE schedule_key = ? AND at = ? AND created_at = ?",
+		timestamp(at), attempts, c.Key, timestamp(c.At), timestamp(c.CreatedAt))
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := r.RowsAffected()
+	return n == 1, err
+}
+
+func (s *MySQLSchedule) Next() (time.Time, bool, error) {
+	var at string
+	err := s.db.QueryRow("SELECT at FROM cqrs_schedule ORDER BY at ASC LIMIT 1").Scan(&at)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	t, err := time.Parse(timestampFormat, at)
+	return t, err == nil, err
+}
+
+func (s *MySQLSchedule) Create(overwrite ...bool) error {
+	if len(overwrite) == 1 && overwrite[0] {
+		if _, err := s.db.Exec("DROP TABLE IF EXISTS cqrs_schedule;"); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(createScheduleTable)
+	return err
+}
+
+// timestampFormat sorts as text the same way as time.
+const timestampFormat = "2006-01-02 15:04:05.000000"
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(timestampFormat)
+}
+
+const createScheduleTable = `CREATE TABLE IF NOT EXISTS cqrs_schedule (
+  schedule_key varchar(191) NOT NULL,
+  aggregate_id varchar(255) NOT NULL,
+  command varchar(255) NOT NULL,
+  payload TEXT NOT NULL,
+  meta text,
+  at varchar(26) NOT NULL,
+  attempts int(11) NOT NULL,
+  created_at varchar(26) NOT NULL,
+  PRIMARY KEY (schedule_key),
+  KEY at (at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`
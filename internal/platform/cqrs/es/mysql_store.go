@@ -5,19 +5,35 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 type MySQL struct {
 	db *sql.DB
 }
 
+// Append events in one transaction, so none of them is stored when one fails.
 func (s *MySQL) Append(a AggregateEvents, expectedVersion uint) error {
-	v := s.version(a.Aggregate)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var v uint
+	err = tx.QueryRow("SELECT COALESCE(MAX(sequence), 0) FROM cqrs_events WHERE aggregate_id = ? AND aggregate_name = ?", a.ID, a.Type).Scan(&v)
+	if err != nil {
+		return err
+	}
+
+	if v != expectedVersion {
+		return conflict(a.Aggregate, expectedVersion)
+	}
+
 	now := time.Now()
 	nowf := now.Format("2006-01-02 15:04:05")
-	//todo expectedVersion need to be determined by mysql and compared (eventual consistency)
-	stmt, err := s.db.Prepare(insertEvent)
+	stmt, err := tx.Prepare(insertEvent)
 	if err != nil {
 		return err
 	}
@@ -30,12 +46,16 @@ func (s *MySQL) Append(a AggregateEvents, expectedVersion uint) error {
 		a.Events[i].CreatedAt = now
 
 		_, err := stmt.Exec(a.ID, string(a.Type), string(e.Type), v, nowf, e.Data, e.Meta)
+		if err, ok := err.(*mysql.MySQLError); ok && err.Number == 1062 {
+			return conflict(a.Aggregate, expectedVersion)
+		}
+
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (s *MySQL) FromVersion(a Aggregate, v uint) (AggregateEvents, error) {
@@ -44,7 +64,7 @@ func (s *MySQL) FromVersion(a Aggregate, v uint) (AggregateEvents, error) {
 	var r *sql.Rows
 	var err error
 
-	r, err = s.db.Query("SELECT * FROM cqrs_events WHERE aggregate_id = ? AND aggregate_name = ? AND sequence >= ? ORDER BY sequence ASC",
+	r, err = s.db.Query(selectEvents+" WHERE aggregate_id = ? AND aggregate_name = ? AND sequence >= ? ORDER BY sequence ASC",
 		a.ID, a.Type, v)
 
 	if err != nil {
@@ -71,60 +91,116 @@ func (s *MySQL) FromVersion(a Aggregate, v uint) (AggregateEvents, error) {
 }
 
 func (s *MySQL) All(aggregate string) ([]AggregateEvents, error) {
-	return s.all(`
-	SELECT *
-		FROM cqrs_events
+	return s.all(selectEvents+`
 		WHERE aggregate_name = ?
 		ORDER BY
 			aggregate_id,
 			sequence ASC`, aggregate)
 }
 
-func (s *MySQL) Copy(aggregate, src string, after uint, dst string) error {
-	q := `INSERT INTO cqrs_events (aggregate_id, aggregate_name, name, sequence, created_at, payload, meta) 
-			SELECT ?, aggregate_name, name, sequence, created_at, payload, meta 
-				FROM cqrs_events 
-				WHERE 
-					aggregate_id = ? 
-					AND aggregate_name = ? 
-					AND sequence > ?`
+// After implements Feed, reading events by their position.
+func (s *MySQL) After(position uint64, limit int) ([]AggregateEvents, error) {
+	return readFeed(s.db, selectFeed+" WHERE position > ? ORDER BY position ASC LIMIT ?", position, limit)
+}
+
+func (s *MySQL) Head() (uint64, error) {
+	var position uint64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(position), 0) FROM cqrs_events").Scan(&position)
+
+	return position, err
+}
+
+// Position implements Positions, reading it from cqrs_positions table.
+func (s *MySQL) Position(subscription string) (uint64, bool, error) {
+	var position uint64
+	err := s.db.QueryRow("SELECT position FROM cqrs_positions WHERE subscription = ?", subscription).Scan(&position)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+
+	return position, err == nil, err
+}
+
+func (s *MySQL) SetPosition(subscription string, position uint64) error {
+	_, err := s.db.Exec(`INSERT INTO cqrs_positions (subscription, position) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE position = VALUES(position)`, subscription, position)
+
+	return err
+}
 
-	stmt, err := s.db.Prepare(q)
+// Copy appends events of src aggregate from given version to dst aggregate,
+// numbered after its last version, in one transaction.
+func (s *MySQL) Copy(aggregate, src string, from uint, dst string) error {
+	if from == 0 {
+		from = 1
+	}
+
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	defer stmt.Close()
+	defer tx.Rollback()
+
+	var v uint
+	err = tx.QueryRow("SELECT COALESCE(MAX(sequence), 0) FROM cqrs_events WHERE aggregate_id = ? AND aggregate_name = ?", dst, aggregate).Scan(&v)
+	if err != nil {
+		return err
+	}
+
+	// versions of aggregate follow each other, so copied event has version
+	// after last one of dst, increased by its distance from first copied one.
+	q := `INSERT INTO cqrs_events (aggregate_id, aggregate_name, name, sequence, created_at, payload, meta)
+			SELECT ?, aggregate_name, name, sequence - ? + ? + 1, created_at, payload, meta
+				FROM cqrs_events
+				WHERE
+					aggregate_id = ?
+					AND aggregate_name = ?
+					AND sequence >= ?
+				ORDER BY sequence ASC`
+
+	_, err = tx.Exec(q, dst, from, v, src, aggregate, from)
+	if err, ok := err.(*mysql.MySQLError); ok && err.Number == 1062 {
+		return conflict(Aggregate{ID: dst, Type: aggregate}, v)
+	}
 
-	if _, err := stmt.Exec(dst, src, aggregate, after); err != nil {
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (s *MySQL) Create(overwrite ...bool) error {
 	if len(overwrite) == 1 && overwrite[0] {
-		stmt, err := s.db.Prepare("DROP TABLE IF EXISTS cqrs_events;")
-		if err != nil {
-			return err
+		for _, q := range []string{"DROP TABLE IF EXISTS cqrs_events;", "DROP TABLE IF EXISTS cqrs_positions;"} {
+			if _, err := s.db.Exec(q); err != nil {
+				return err
+			}
 		}
+	}
 
-		defer stmt.Close()
-		_, err = stmt.Exec()
-		if err != nil {
+	for _, q := range []string{createEventsTable, createPositionsTable} {
+		if _, err := s.db.Exec(q); err != nil {
 			return err
 		}
 	}
 
-	stmt, err := s.db.Prepare(createEventsTable)
-	if err != nil {
+	return s.addPosition()
+}
+
+// addPosition migrates cqrs_events table created before events had position,
+// stored events get positions in order of primary key.
+func (s *MySQL) addPosition() error {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = 'cqrs_events' AND column_name = 'position'`).Scan(&n)
+
+	if err != nil || n > 0 {
 		return err
 	}
 
-	defer stmt.Close()
-	_, err = stmt.Exec()
-
+	_, err = s.db.Exec(addPositionColumn)
 	return err
 }
 
@@ -286,6 +362,14 @@ const insertEvent = `INSERT INTO
 	cqrs_events(aggregate_id, aggregate_name, name, sequence, created_at, payload, meta)
 	VALUES(?, ?, ?, ?, ?, ?, ?)`
 
+const selectEvents = `SELECT
+	aggregate_id, aggregate_name, name, sequence, created_at, payload, meta
+	FROM cqrs_events`
+
+const selectFeed = `SELECT
+	aggregate_id, aggregate_name, name, sequence, created_at, payload, meta, position
+	FROM cqrs_events`
+
 const createEventsTable = `CREATE TABLE IF NOT EXISTS cqrs_events (
   aggregate_id varchar(255) NOT NULL,
   aggregate_name varchar(255) NOT NULL,
@@ -294,5 +378,17 @@ const createEventsTable = `CREATE TABLE IF NOT EXISTS cqrs_events (
   created_at varchar(255) NOT NULL,
   payload TEXT NOT NULL,
   meta text,
-  PRIMARY KEY (aggregate_id, aggregate_name, sequence)
+  position bigint NOT NULL AUTO_INCREMENT,
+  PRIMARY KEY (aggregate_id, aggregate_name, sequence),
+  UNIQUE KEY position (position)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;`
+
+const addPositionColumn = `ALTER TABLE cqrs_events
+  ADD COLUMN position bigint NOT NULL AUTO_INCREMENT,
+  ADD UNIQUE KEY position (position)`
+
+const createPositionsTable = `CREATE TABLE IF NOT EXISTS cqrs_positions (
+  subscription varchar(255) NOT NULL,
+  position bigint NOT NULL,
+  PRIMARY KEY (subscription)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`
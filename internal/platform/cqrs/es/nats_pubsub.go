@@ -0,0 +1,349 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sokool/gokit/log"
+)
+
+const ntag = "es.nats"
+
+// natsEscaper escapes characters of route, which are not allowed in NATS
+// subject tokens, on top of escaping done by route.
+var natsEscaper = strings.NewReplacer(">", "%3E", " ", "%20", "\t", "%09", "\r", "%0D", "\n", "%0A")
+
+// natsPubSub publishes events into JetStream stream under
+// "<stream>.<id>.<aggregate>.<event>" subjects. Every named Subscription is
+// a durable consumer, which remembers acknowledged position between restarts.
+// Events which handler failed to process on last delivery are moved into dead
+// letters stream, under "<dead letters>.<consumer>.<id>.<aggregate>.<event>"
+// subjects. Consumer has one event pending acknowledgement at once by default,
+// so event is not overtaken by next ones, while its delivery is retried.
+type natsPubSub struct {
+	name        string
+	storage     jetstream.StorageType
+	maxDeliver  int
+	maxPending  int
+	ackWait     time.Duration
+	nakDelay    time.Duration
+	deadLetters string
+	timeout     time.Duration
+	serializer  Serializer
+
+	conn   *nats.Conn
+	closed chan struct{}
+	js     jetstream.JetStream
+	stream jetstream.Stream
+
+	mu        sync.Mutex
+	consumers []jetstream.ConsumeContext
+}
+
+// NewNATSPubSub connects to NATS server and creates (or updates) JetStream
+// stream of given name, waiting for it until ctx is done.
+func NewNATSPubSub(ctx context.Context, url, stream string, oo ...NATSOption) (*natsPubSub, error) {
+	n := &natsPubSub{
+		name:        stream,
+		storage:     jetstream.FileStorage,
+		maxDeliver:  5,
+		maxPending:  1,
+		ackWait:     30 * time.Second,
+		nakDelay:    time.Second,
+		deadLetters: stream + "-dead",
+		timeout:     10 * time.Second,
+		serializer:  jsonSerializer{},
+		closed:      make(chan struct{}),
+	}
+
+	for _, o := range oo {
+		o(n)
+	}
+
+	var err error
+	closed := nats.ClosedHandler(func(*nats.Conn) { close(n.closed) })
+	if n.conn, err = nats.Connect(url, nats.Name(stream), nats.MaxReconnects(-1), closed); err != nil {
+		return nil, err
+	}
+
+	if n.js, err = jetstream.New(n.conn); err != nil {
+		n.conn.Close()
+		return nil, err
+	}
+
+	n.stream, err = n.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     stream,
+		Subjects: []string{stream + ".>"},
+		Storage:  n.storage,
+	})
+
+	if err != nil {
+		n.conn.Close()
+		return nil, fmt.Errorf("%s stream %s", stream, err)
+	}
+
+	if n.deadLetters != "" {
+		_, err = n.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:     n.deadLetters,
+			Subjects: []string{n.deadLetters + ".>"},
+			Storage:  n.storage,
+		})
+
+		if err != nil {
+			n.conn.Close()
+			return nil, fmt.Errorf("%s stream %s", n.deadLetters, err)
+		}
+	}
+
+	log.Info(ntag, "connected to %s stream", stream)
+	return n, nil
+}
+
+// Publish stores events in stream, returning when JetStream acknowledged all
+// of them. Every event is published with ID, so repeated Publish of the same
+// events within stream duplicates window is ignored by server.
+func (n *natsPubSub) Publish(a AggregateEvents) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	for _, e := range a.Events {
+		b, err := n.serializer.Marshal(envelope{Aggregate: a.Aggregate, Event: e})
+		if err != nil {
+			return err
+		}
+
+		subject := n.subject(route(a.ID, a.Type, e.Type))
+		m := nats.NewMsg(subject)
+		m.Data = b
+		m.Header.Set("Aggregate-Id", a.ID)
+		m.Header.Set("Aggregate-Type", a.Type)
+		m.Header.Set("Event", e.Type)
+		m.Header.Set("Version", strconv.FormatUint(uint64(e.Version), 10))
+
+		if _, err := n.js.PublishMsg(ctx, m, jetstream.WithMsgID(fmt.Sprintf("%s.%d", subject, e.Version))); err != nil {
+			return fmt.Errorf("%s publish %s", subject, err)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe consumes events published since now, named Subscription continues
+// from last acknowledged event, when it was consumed before.
+func (n *natsPubSub) Subscribe(s Subscription) error {
+	return n.SubscribeFrom(s, 0)
+}
+
+// SubscribeFrom replays stream events starting from given stream sequence,
+// before delivering new ones. Sequence is ignored when durable consumer of
+// named Subscription already exists, its subjects are updated then.
+func (n *natsPubSub) SubscribeFrom(s Subscription, sequence uint64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	var subjects []string
+	for _, r := range s.routes {
+		subjects = append(subjects, n.subject(r))
+	}
+
+	c := jetstream.ConsumerConfig{
+		FilterSubjects: subjects,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		DeliverPolicy:  jetstream.DeliverNewPolicy,
+		MaxDeliver:     n.maxDeliver,
+		MaxAckPending:  n.maxPending,
+		AckWait:        n.ackWait,
+	}
+
+	if sequence > 0 {
+		c.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		c.OptStartSeq = sequence
+	}
+
+	var consumer jetstream.Consumer
+	var err error
+	if s.name == "" {
+		c.InactiveThreshold = time.Minute
+		consumer, err = n.stream.CreateConsumer(ctx, c)
+	} else {
+		// start of existing consumer can not be changed, its position is
+		// kept, while subjects and delivery settings are updated.
+		c.Durable = consumerName(s.name)
+		if o, err := n.stream.Consumer(ctx, c.Durable); err == nil {
+			c.DeliverPolicy = o.CachedInfo().Config.DeliverPolicy
+			c.OptStartSeq = o.CachedInfo().Config.OptStartSeq
+			c.OptStartTime = o.CachedInfo().Config.OptStartTime
+		} else if !errors.Is(err, jetstream.ErrConsumerNotFound) {
+			return fmt.Errorf("%s consumer %s", s.name, err)
+		}
+
+		consumer, err = n.stream.CreateOrUpdateConsumer(ctx, c)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s consumer %s", s.name, err)
+	}
+
+	name := consumer.CachedInfo().Name
+	cc, err := consumer.Consume(func(m jetstream.Msg) {
+		var e envelope
+		if err := n.serializer.Unmarshal(m.Data(), &e); err != nil {
+			log.Error(ntag, fmt.Errorf("%s %s %s", s.name, m.Subject(), err))
+			n.bury(name, m, err)
+			return
+		}
+
+		if err := s.handler(e.Aggregate, e.Event); err != nil {
+			log.Error(ntag, fmt.Errorf("%s %s.%s.%s[v.%d] %s", s.name, e.Aggregate.ID, e.Aggregate.Type, e.Type, e.Version, err))
+			n.retry(name, m, err)
+			return
+		}
+
+		if err := m.Ack(); err != nil {
+			log.Error(ntag, fmt.Errorf("%s ack %s", s.name, err))
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("%s consume %s", s.name, err)
+	}
+
+	n.mu.Lock()
+	n.consumers = append(n.consumers, cc)
+	n.mu.Unlock()
+
+	log.Debug(ntag, "%s consumes %v", s.name, subjects)
+	return nil
+}
+
+// Close stops consumers and drains connection, waiting until handlers
+// process delivered events and connection is closed.
+func (n *natsPubSub) Close() error {
+	n.mu.Lock()
+	for _, c := range n.consumers {
+		c.Stop()
+	}
+	n.consumers = nil
+	n.mu.Unlock()
+
+	if err := n.conn.Drain(); err != nil {
+		return err
+	}
+
+	<-n.closed
+	if err := n.conn.LastError(); errors.Is(err, nats.ErrDrainTimeout) {
+		return err
+	}
+
+	return nil
+}
+
+// retry failed message after delay growing with deliveries, message failed on
+// last delivery is moved to dead letters.
+func (n *natsPubSub) retry(consumer string, m jetstream.Msg, err error) {
+	md, merr := m.Metadata()
+	if merr != nil || n.maxDeliver <= 0 || md.NumDelivered < uint64(n.maxDeliver) {
+		var d time.Duration
+		if merr == nil {
+			d = n.nakDelay * time.Duration(md.NumDelivered)
+		}
+
+		if err := m.NakWithDelay(d); err != nil {
+			log.Error(ntag, fmt.Errorf("%s nak %s", consumer, err))
+		}
+
+		return
+	}
+
+	n.bury(consumer, m, err)
+}
+
+// bury moves message into dead letters stream and terminates its delivery,
+// without dead letters message is dropped.
+func (n *natsPubSub) bury(consumer string, m jetstream.Msg, err error) {
+	if n.deadLetters == "" {
+		log.Error(ntag, fmt.Errorf("%s %s dropped: %s", consumer, m.Subject(), err))
+		m.Term()
+		return
+	}
+
+	d := nats.NewMsg(n.deadLetters + "." + consumer + strings.TrimPrefix(m.Subject(), n.name))
+	d.Data = m.Data()
+	for k, v := range m.Headers() {
+		d.Header[k] = v
+	}
+	d.Header.Set("Error", err.Error())
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	if _, perr := n.js.PublishMsg(ctx, d); perr != nil {
+		log.Error(ntag, fmt.Errorf("%s %s not moved to dead letters: %s", consumer, m.Subject(), perr))
+		m.NakWithDelay(n.nakDelay)
+		return
+	}
+
+	log.Info(ntag, "%s %s moved to dead letters", consumer, m.Subject())
+	m.Term()
+}
+
+func (n *natsPubSub) subject(route string) string {
+	return n.name + "." + natsEscaper.Replace(route)
+}
+
+// consumerName replaces characters which are not allowed in durable name.
+func consumerName(s string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(s)
+}
+
+type NATSOption func(*natsPubSub)
+
+// NATSMemoryStorage keeps stream in memory of NATS server, instead of files.
+func NATSMemoryStorage() NATSOption {
+	return func(n *natsPubSub) { n.storage = jetstream.MemoryStorage }
+}
+
+// NATSMaxDeliver limits number of deliveries of event, which handler failed to
+// process. Default is 5.
+func NATSMaxDeliver(max int) NATSOption {
+	return func(n *natsPubSub) { n.maxDeliver = max }
+}
+
+// NATSMaxAckPending limits number of events delivered to subscription and not
+// acknowledged yet. Default is 1, so event which handler failed to process is
+// redelivered before next ones. Greater limit speeds up delivery, but events
+// published after failed one can be handled before its redelivery.
+func NATSMaxAckPending(n int) NATSOption {
+	return func(p *natsPubSub) { p.maxPending = n }
+}
+
+// NATSNakDelay is delay of redelivery of event, which handler failed to
+// process, multiplied by number of its deliveries. Default is 1 second.
+func NATSNakDelay(d time.Duration) NATSOption {
+	return func(n *natsPubSub) { n.nakDelay = d }
+}
+
+// NATSDeadLetters sets name of stream, where events which failed on last
+// delivery are moved. Default is "<stream>-dead", empty name drops them.
+func NATSDeadLetters(stream string) NATSOption {
+	return func(n *natsPubSub) { n.deadLetters = stream }
+}
+
+// NATSAckWait is time after which not acknowledged event is redelivered.
+// Default is 30 seconds.
+func NATSAckWait(d time.Duration) NATSOption {
+	return func(n *natsPubSub) { n.ackWait = d }
+}
+
+// NATSSerializer changes encoding of events in message data, JSON is used by
+// default.
+func NATSSerializer(s Serializer) NATSOption {
+	return func(n *natsPubSub) { n.serializer = s }
+}
@@ -0,0 +1,233 @@
+package es_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestNATSPubSub(t *testing.T) {
+	url := natsServer(t)
+	events := make(chan es.AggregateEvents, 16)
+
+	n := natsPubSub(t, url)
+	s := es.NewSubscription(collect(events)).Name("books").AggregateEvents("Book", "Created")
+	if err := n.Subscribe(*s); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, n, "dune.1 >*", "Book", "Created")
+	publish(t, n, "dune.1 >*", "Author", "Created")
+
+	expect(t, events, "dune.1 >*.Book.Created")
+	n.Close()
+
+	// durable consumer continues from acknowledged event with new subjects
+	n = natsPubSub(t, url)
+	publish(t, n, "dune", "Book", "Renamed")
+
+	s = es.NewSubscription(collect(events)).Name("books").AggregateEvents("Book", "Created", "Renamed")
+	if err := n.Subscribe(*s); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, events, "dune.Book.Renamed")
+	publish(t, n, "dune", "Book", "Created")
+	expect(t, events, "dune.Book.Created")
+}
+
+func TestNATSDeadLetters(t *testing.T) {
+	url := natsServer(t)
+	deliveries := make(chan es.AggregateEvents, 16)
+
+	n := natsPubSub(t, url, es.NATSMaxDeliver(3), es.NATSNakDelay(time.Millisecond))
+	s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+		collect(deliveries)(a, e)
+		return fmt.Errorf("database is down")
+	})
+
+	if err := n.Subscribe(*s.Name("books").AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, n, "dune", "Book", "Created")
+	for i := 0; i < 3; i++ {
+		expect(t, deliveries, "dune.Book.Created")
+	}
+
+	c, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	js, err := jetstream.New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m, err := js.OrderedConsumer(ctx, "events-dead", jetstream.OrderedConsumerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := m.Next(jetstream.FetchMaxWait(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Subject() != "events-dead.books.dune.Book.Created" || d.Headers().Get("Error") != "database is down" {
+		t.Fatalf("dead letter expected, got %s %v", d.Subject(), d.Headers())
+	}
+
+	select {
+	case a := <-deliveries:
+		t.Fatalf("no more deliveries expected, got %s", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNATSRetryOrder(t *testing.T) {
+	url := natsServer(t)
+	deliveries := make(chan es.AggregateEvents, 16)
+
+	n := natsPubSub(t, url, es.NATSNakDelay(50*time.Millisecond))
+	failed := false
+	s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+		collect(deliveries)(a, e)
+		if !failed {
+			failed = true
+			return fmt.Errorf("database is down")
+		}
+
+		return nil
+	})
+
+	if err := n.Subscribe(*s.Name("books").AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	// next event is not delivered, until failed one is redelivered.
+	publish(t, n, "dune", "Book", "Created", "Renamed")
+	expect(t, deliveries, "dune.Book.Created")
+	expect(t, deliveries, "dune.Book.Created")
+	expect(t, deliveries, "dune.Book.Renamed")
+}
+
+func TestNATSClose(t *testing.T) {
+	url := natsServer(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	handled := false
+
+	n := natsPubSub(t, url)
+	s := es.NewSubscription(func(es.Aggregate, es.Event) error {
+		close(started)
+		<-release
+		handled = true
+		return nil
+	})
+
+	if err := n.Subscribe(*s.Name("books").AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, n, "dune", "Book", "Created")
+	<-started
+
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !handled {
+		t.Fatal("Close waiting for handler expected")
+	}
+}
+
+func natsServer(t *testing.T) string {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	t.Cleanup(s.Shutdown)
+
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+
+	return s.ClientURL()
+}
+
+func natsPubSub(t *testing.T, url string, oo ...es.NATSOption) pubSub {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := es.NewNATSPubSub(ctx, url, "events", append(oo, es.NATSMemoryStorage())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+type pubSub interface {
+	es.PublishSubscriber
+	Close() error
+}
+
+// collect sends every delivered event into channel.
+func collect(c chan<- es.AggregateEvents) func(es.Aggregate, es.Event) error {
+	return func(a es.Aggregate, e es.Event) error {
+		c <- es.AggregateEvents{Aggregate: a, Events: []es.Event{e}}
+		return nil
+	}
+}
+
+// publish events of aggregate in versions starting from 1.
+func publish(t *testing.T, p es.Publisher, id, aggregate string, events ...string) {
+	t.Helper()
+
+	a := es.AggregateEvents{Aggregate: es.Aggregate{ID: id, Type: aggregate}}
+	for i, e := range events {
+		a.Events = append(a.Events, es.Event{Type: e, Version: uint(i + 1), Data: []byte(`{}`), CreatedAt: time.Now()})
+	}
+
+	if err := p.Publish(a); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect event delivered into channel, named as <id>.<aggregate>.<event>.
+func expect(t *testing.T, c <-chan es.AggregateEvents, event string) {
+	t.Helper()
+
+	select {
+	case a := <-c:
+		if n := fmt.Sprintf("%s.%s.%s", a.ID, a.Type, a.Events[0].Type); n != event {
+			t.Fatalf("%s event expected, got %s", event, n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s event expected", event)
+	}
+}
@@ -0,0 +1,262 @@
+package es
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sokool/gokit/log"
+)
+
+const ptag = "es.postgres"
+
+// Postgres stores events in cqrs_events table, notifying cqrs_events channel
+// about every insert, see NewPostgresSubscriber.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(c *sql.DB) *Postgres {
+	return &Postgres{
+		db: c,
+	}
+}
+
+func (s *Postgres) Append(a AggregateEvents, expectedVersion uint) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var v uint
+	err = tx.QueryRow("SELECT COALESCE(MAX(sequence), 0) FROM cqrs_events WHERE aggregate_id = $1 AND aggregate_name = $2", a.ID, a.Type).
+		Scan(&v)
+
+	if err != nil {
+		return err
+	}
+
+	if v != expectedVersion {
+		return conflict(a.Aggregate, expectedVersion)
+	}
+
+	now := time.Now()
+	stmt, err := tx.Prepare(`INSERT INTO
+		cqrs_events(aggregate_id, aggregate_name, name, sequence, created_at, payload, meta)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
+		RETURNING position`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for i, e := range a.Events {
+		v++
+		a.Events[i].Version = v
+		a.Events[i].CreatedAt = now
+
+		err := stmt.QueryRow(a.ID, a.Type, e.Type, v, now, e.Data, e.Meta).Scan(&a.Events[i].Position)
+		if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+			return conflict(a.Aggregate, expectedVersion)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+			return conflict(a.Aggregate, expectedVersion)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *Postgres) FromVersion(a Aggregate, v uint) (AggregateEvents, error) {
+	out := AggregateEvents{Aggregate: a}
+	r, err := s.db.Query(selectFeed+" WHERE aggregate_id = $1 AND aggregate_name = $2 AND sequence >= $3 ORDER BY sequence ASC",
+		a.ID, a.Type, v)
+
+	if err != nil {
+		return out, err
+	}
+
+	defer r.Close()
+
+	for r.Next() {
+		e := Event{}
+		if err := r.Scan(&out.ID, &out.Type, &e.Type, &e.Version, &e.CreatedAt, &e.Data, &e.Meta, &e.Position); err != nil {
+			return out, err
+		}
+
+		out.Events = append(out.Events, e)
+	}
+
+	return out, r.Err()
+}
+
+func (s *Postgres) All(aggregate string) ([]AggregateEvents, error) {
+	return readFeed(s.db, selectFeed+" WHERE aggregate_name = $1 ORDER BY aggregate_id, sequence ASC", aggregate)
+}
+
+// Copy appends events of src aggregate from given version to dst aggregate,
+// numbered after its last version, in one transaction.
+func (s *Postgres) Copy(aggregate, src string, from uint, dst string) error {
+	if from == 0 {
+		from = 1
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var v uint
+	err = tx.QueryRow("SELECT COALESCE(MAX(sequence), 0) FROM cqrs_events WHERE aggregate_id = $1 AND aggregate_name = $2", dst, aggregate).
+		Scan(&v)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO cqrs_events (aggregate_id, aggregate_name, name, sequence, created_at, payload, meta)
+		SELECT $1, aggregate_name, name, sequence - $2 + $3 + 1, created_at, payload, meta
+			FROM cqrs_events
+			WHERE
+				aggregate_id = $4
+				AND aggregate_name = $5
+				AND sequence >= $2
+			ORDER BY sequence ASC`, dst, from, v, src, aggregate)
+
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		return conflict(Aggregate{ID: dst, Type: aggregate}, v)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// After implements Feed, reading events by their position.
+func (s *Postgres) After(position uint64, limit int) ([]AggregateEvents, error) {
+	return readFeed(s.db, selectFeed+" WHERE position > $1 ORDER BY position ASC LIMIT $2", position, limit)
+}
+
+func (s *Postgres) Head() (uint64, error) {
+	var position uint64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(position), 0) FROM cqrs_events").Scan(&position)
+
+	return position, err
+}
+
+// Position implements Positions, reading it from cqrs_positions table.
+func (s *Postgres) Position(subscription string) (uint64, bool, error) {
+	var position uint64
+	err := s.db.QueryRow("SELECT position FROM cqrs_positions WHERE subscription = $1", subscription).Scan(&position)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+
+	return position, err == nil, err
+}
+
+func (s *Postgres) SetPosition(subscription string, position uint64) error {
+	_, err := s.db.Exec(`INSERT INTO cqrs_positions (subscription, position) VALUES ($1, $2)
+		ON CONFLICT (subscription) DO UPDATE SET position = EXCLUDED.position`, subscription, position)
+
+	return err
+}
+
+func (s *Postgres) Create(overwrite ...bool) error {
+	if len(overwrite) == 1 && overwrite[0] {
+		if _, err := s.db.Exec("DROP TABLE IF EXISTS cqrs_events, cqrs_positions;"); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(createPostgresTables)
+	return err
+}
+
+type postgresSubscriber struct {
+	*feedSubscriber
+	listener *pq.Listener
+}
+
+// NewPostgresSubscriber delivers events stored by Postgres store as soon as
+// they are notified by cqrs_events trigger. Store is also read every interval,
+// in case notification was lost.
+func NewPostgresSubscriber(s *Postgres, dsn string, interval time.Duration) (*postgresSubscriber, error) {
+	f := NewPollingSubscriber(s, s, interval)
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(e pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error(ptag, err)
+		}
+
+		if e == pq.ListenerEventReconnected {
+			f.Notify()
+		}
+	})
+
+	if err := l.Listen("cqrs_events"); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for range l.Notify {
+			f.Notify()
+		}
+	}()
+
+	return &postgresSubscriber{feedSubscriber: f, listener: l}, nil
+}
+
+func (p *postgresSubscriber) Close() error {
+	if err := p.listener.Close(); err != nil {
+		log.Error(ptag, err)
+	}
+
+	return p.feedSubscriber.Close()
+}
+
+const createPostgresTables = `
+CREATE TABLE IF NOT EXISTS cqrs_events (
+  aggregate_id varchar(255) NOT NULL,
+  aggregate_name varchar(255) NOT NULL,
+  name varchar(255) NOT NULL,
+  sequence integer NOT NULL,
+  created_at timestamptz NOT NULL,
+  payload bytea NOT NULL,
+  meta bytea,
+  position bigserial UNIQUE,
+  PRIMARY KEY (aggregate_id, aggregate_name, sequence)
+);
+
+CREATE TABLE IF NOT EXISTS cqrs_positions (
+  subscription varchar(255) NOT NULL PRIMARY KEY,
+  position bigint NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION cqrs_events_notify() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('cqrs_events', '');
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS cqrs_events_notify ON cqrs_events;
+CREATE TRIGGER cqrs_events_notify AFTER INSERT ON cqrs_events
+  FOR EACH STATEMENT EXECUTE PROCEDURE cqrs_events_notify();`
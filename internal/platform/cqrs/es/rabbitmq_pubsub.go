@@ -1,8 +1,10 @@
 package es
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"hash/fnv"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -11,243 +13,999 @@ import (
 	"github.com/streadway/amqp"
 )
 
-const rtag = "es.rabbitMQ"
+const (
+	rtag = "es.rabbitMQ"
+	// maxRoutingKey is length limit of AMQP short string.
+	maxRoutingKey = 255
+)
+
+var (
+	errDisconnected = fmt.Errorf("publisher disconnected")
+	errClosed       = fmt.Errorf("rabbitMQ pubsub closed")
+)
 
 type rabbitMQ struct {
-	url string
+	url         string
+	prefetch    int
+	requeue     bool
+	retryDelay  time.Duration
+	deadLetters string
+	mandatory   bool
+	timeout     time.Duration
+	buffer      int
+	channels    bool
+	partitions  int
+	reconnect   ReconnectStrategy
+	serializer  Serializer
+	contentType string
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	started  chan struct{}
+	done     chan struct{}
+	err      error
+	handlers sync.WaitGroup
+
+	subscribe  chan subscribing
+	publish    chan publishing
+	publishers chan *rabbitPublisher
+	published  chan struct{}
+	// events published while disconnected, they are sent in order after
+	// reconnection, owned by publishing goroutine until it is done.
+	pending []publishing
+}
+
+// publishing is a request of publishing events, answered on done channel
+// after broker confirms them. Sent counts confirmed events, so they are not
+// published again after reconnection.
+type publishing struct {
+	events AggregateEvents
+	sent   int
+	done   chan error
+}
 
-	errors chan error
-	finish chan error
+type subscribing struct {
+	subscription Subscription
+	done         chan error
+}
 
-	subscribers struct {
-		assign chan Subscription
-		read   chan string
+type rabbitConnection struct {
+	connection *amqp.Connection
+	publisher  *rabbitPublisher
+	subscriber *amqp.Channel
+	channels   []*amqp.Channel
+	consumers  []rabbitConsumer
+}
+
+type rabbitPublisher struct {
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	returned map[string]amqp.Return
+	tag      uint64
+}
+
+type rabbitConsumer struct {
+	tag     string
+	channel *amqp.Channel
+}
+
+// ReconnectStrategy tells how long to wait before given attempt (counted from
+// 1) of connecting to broker, which failed with err. Returning false stops
+// reconnecting and closes pubsub.
+type ReconnectStrategy func(attempt int, err error) (time.Duration, bool)
+
+// NewRabbitMQPubSub connects to RabbitMQ, waiting for connection until ctx is
+// done. Given ctx controls only startup, pubsub lives until Close is called or
+// ReconnectStrategy gives up.
+func NewRabbitMQPubSub(ctx context.Context, url string, oo ...RabbitMQOption) (*rabbitMQ, error) {
+	r := &rabbitMQ{
+		url:         url,
+		requeue:     true,
+		retryDelay:  time.Second,
+		timeout:     10 * time.Second,
+		buffer:      1000,
+		partitions:  8,
+		reconnect:   reconnect,
+		serializer:  jsonSerializer{},
+		contentType: "application/json",
+		started:     make(chan struct{}),
+		done:        make(chan struct{}),
+		subscribe:   make(chan subscribing),
+		publish:     make(chan publishing),
+		publishers:  make(chan *rabbitPublisher, 1),
+		published:   make(chan struct{}),
 	}
 
-	rabbit struct {
-		connect   chan string
-		subscribe chan Subscription
-		publish   chan AggregateEvents
+	for _, o := range oo {
+		o(r)
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	go r.publishing()
+	go r.run()
+
+	select {
+	case <-r.started:
+		return r, nil
+	case <-r.done:
+		return nil, r.err
+	case <-ctx.Done():
+		r.Close()
+		return nil, fmt.Errorf("rabbitMQ connecting %s", ctx.Err())
 	}
 }
 
-// [options]
-// - channel per subscription
-// - channel per aggregate
-// - with context to shutdown it
-// - function receive info after X number of retries
-// - wait x sec before reconnect or strategy function
-// - message serialization method
-//
-func NewRabbitMQPubSub(url string) (*rabbitMQ, error) {
-	r := &rabbitMQ{
-		url:    url,
-		errors: make(chan error),
-		finish: make(chan error),
-
-		subscribers: struct {
-			assign chan Subscription
-			read   chan string
-		}{
-			make(chan Subscription),
-			make(chan string),
-		},
-		rabbit: struct {
-			connect   chan string
-			subscribe chan Subscription
-			publish   chan AggregateEvents
-		}{
-			make(chan string),
-			make(chan Subscription),
-			make(chan AggregateEvents),
-		},
+// Publish sends events to RabbitMQ and waits until broker confirms them. When
+// connection is lost, events are buffered (up to RabbitMQBuffer) and sent after
+// reconnection, in that case Publish gives up waiting after timeout
+// (RabbitMQPublishTimeout).
+func (r *rabbitMQ) Publish(a AggregateEvents) error {
+	p := publishing{events: a, done: make(chan error, 1)}
+	select {
+	case r.publish <- p:
+	case <-r.ctx.Done():
+		return r.closed()
 	}
 
-	var attempts int
+	select {
+	case err := <-p.done:
+		return err
+	case <-after(r.timeout):
+		return fmt.Errorf("%s.%s not confirmed in %s, events are buffered until reconnection", a.ID, a.Type, r.timeout)
+	}
+}
 
-	subscriber := func() {
-		var ss []Subscription
-		for {
-			select {
-			case s := <-r.subscribers.assign:
-				ss = append(ss, s)
-				r.rabbit.subscribe <- s
+// Subscribe starts consuming events of given Subscription, it is consumed
+// again after every reconnection. Subscription made while connection is lost
+// is consumed after reconnection, when broker rejects it then, it is dropped
+// and logged, other subscriptions are consumed.
+func (r *rabbitMQ) Subscribe(s Subscription) error {
+	if err := r.validate(s); err != nil {
+		return fmt.Errorf("%s subscribe %s", s.name, err)
+	}
+
+	q := subscribing{subscription: s, done: make(chan error, 1)}
+	select {
+	case r.subscribe <- q:
+		return <-q.done
+	case <-r.ctx.Done():
+		return r.closed()
+	}
+}
+
+// Close stops consuming, waits until handlers finish processing of delivered
+// events, fails buffered publishings and disconnects from broker.
+func (r *rabbitMQ) Close() error {
+	r.cancel()
+	<-r.done
+
+	if r.err == errClosed {
+		return nil
+	}
+
+	return r.err
+}
 
-			case <-r.subscribers.read:
-				for _, s := range ss {
-					r.rabbit.subscribe <- s
+// closed does not wait until pubsub is done, since it might be called by
+// handler which Close is waiting for.
+func (r *rabbitMQ) closed() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return errClosed
+	}
+}
+
+func (r *rabbitMQ) run() {
+	var (
+		c         *rabbitConnection
+		lost      chan *amqp.Error
+		ss        []Subscription
+		attempts  int
+		reconnect = after(time.Nanosecond)
+		start     sync.Once
+	)
+
+	retry := func(err error) bool {
+		attempts++
+		wait, ok := r.reconnect(attempts, err)
+		if !ok {
+			r.err = fmt.Errorf("rabbitMQ gave up reconnecting after %d attempts: %s", attempts, err)
+			return false
+		}
+
+		log.Error(rtag, fmt.Errorf("%s, reconnect #%d after %s", err, attempts, wait))
+		reconnect = after(wait)
+		return true
+	}
+
+	defer func() {
+		if r.err == nil {
+			r.err = errClosed
+		}
+
+		r.cancel()
+		<-r.published
+		r.disconnect(c, true)
+		for _, p := range r.pending {
+			p.done <- r.err
+		}
+		close(r.done)
+	}()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+
+		case <-reconnect:
+			reconnect = nil
+			var err error
+			if c, err = r.connect(); err != nil {
+				r.disconnect(c, false)
+				c = nil
+				if !retry(err) {
+					return
 				}
+				break
+			}
+
+			attempts = 0
+			lost = c.connection.NotifyClose(make(chan *amqp.Error, 1))
+			ss = c.resubscribe(r, ss)
+			r.publisher(c.publisher)
+			start.Do(func() { close(r.started) })
+
+		case err, ok := <-lost:
+			lost = nil
+			r.publisher(nil)
+			r.disconnect(c, false)
+			c = nil
+			if !ok {
+				err = &amqp.Error{Reason: "connection closed"}
+			}
+
+			if !retry(err) {
+				return
+			}
+
+		case s := <-r.subscribe:
+			// subscription is consumed after reconnection, when it was
+			// subscribed or deferred.
+			if c == nil {
+				ss = append(ss, s.subscription)
+				s.done <- nil
+				break
+			}
+
+			err := c.subscribe(r, s.subscription)
+			if err != nil {
+				log.Error(rtag, fmt.Errorf("%s subscribe %s", s.subscription.name, err))
+			} else {
+				ss = append(ss, s.subscription)
 			}
+			s.done <- err
 		}
 	}
+}
+
+// publishing sends events with publisher of current connection, waiting for
+// broker confirmations outside of run, so they do not hold back subscriptions
+// and reconnection.
+func (r *rabbitMQ) publishing() {
+	defer close(r.published)
+
+	var p *rabbitPublisher
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+
+		case p = <-r.publishers:
+			if r.pending = r.flush(r.pending, p); len(r.pending) > 0 {
+				p = nil
+			}
+
+		case q := <-r.publish:
+			if len(r.pending) >= r.buffer {
+				q.done <- fmt.Errorf("%s.%s not published, %d publishings already buffered until reconnection", q.events.ID, q.events.Type, len(r.pending))
+				break
+			}
 
-	rabbit := func() {
-		var connection *amqp.Connection
-		var publisher *amqp.Channel
-		var subscriber *amqp.Channel
-		var start sync.Once
+			if r.pending = r.flush(append(r.pending, q), p); len(r.pending) > 0 {
+				p = nil
+				log.Info(rtag, "%d publishings buffered until reconnection", len(r.pending))
+			}
+		}
+	}
+}
 
-		for {
+// publisher hands publisher of new connection, or nil when it is lost, to
+// publishing goroutine, replacing one it has not received yet.
+func (r *rabbitMQ) publisher(p *rabbitPublisher) {
+	for {
+		select {
+		case r.publishers <- p:
+			return
+		default:
 			select {
-			case <-r.rabbit.connect:
-				var err error
-				connection, err = amqp.DialConfig(r.url, amqp.Config{Heartbeat: time.Second})
-				if err != nil {
-					r.errors <- err
-					break
-				}
+			case <-r.publishers:
+			default:
+			}
+		}
+	}
+}
 
-				// Listen errors from rabbitmq. amqp.Error channel is closed
-				// by streadway/amqp, after reconnection.
-				go func() {
-					for err := range connection.NotifyClose(make(chan *amqp.Error)) {
-						r.errors <- err
-					}
-				}()
-				log.Info(rtag, "connected to %s", connection.Config.Vhost)
-
-				if publisher, err = connection.Channel(); err != nil {
-					log.Error(rtag, fmt.Errorf("publisher %s", err))
-					break
-				}
+// validate Subscription before it is consumed, so subscription deferred until
+// reconnection is not rejected by broker for reasons known in advance.
+func (r *rabbitMQ) validate(s Subscription) error {
+	if s.group != "" && r.partitions < 1 {
+		return fmt.Errorf("%s group requires at least one partition", s.group)
+	}
 
-				err = publisher.ExchangeDeclare("events", "topic", false, false, false, false, nil)
-				if err != nil {
-					log.Error(rtag, fmt.Errorf("publisher %s", err))
-					break
-				}
-				log.Debug(rtag, "publish channel established")
+	for _, route := range s.routes {
+		if len(route) > maxRoutingKey {
+			return fmt.Errorf("%s binding key has %d bytes after escaping, AMQP allows %d", route, len(route), maxRoutingKey)
+		}
+	}
 
-				if subscriber, err = connection.Channel(); err != nil {
-					log.Error(rtag, fmt.Errorf("channel %s", err))
-					break
-				}
+	return nil
+}
 
-				log.Debug(rtag, "subscribe channel established")
-				attempts = 0
-				r.subscribers.read <- "after reconnection"
+// connect dials broker, declares exchanges and opens channels. On error,
+// partially established connection is returned, so it can be released.
+func (r *rabbitMQ) connect() (*rabbitConnection, error) {
+	connection, err := amqp.DialConfig(r.url, amqp.Config{Heartbeat: time.Second})
+	if err != nil {
+		return nil, err
+	}
 
-				start.Do(func() {
-					close(r.finish)
-				})
+	c := &rabbitConnection{connection: connection}
+	log.Info(rtag, "connected to %s", connection.Config.Vhost)
 
-			case s := <-r.rabbit.subscribe:
-				if err := r.subscribe(s, subscriber); err != nil {
-					log.Error(rtag, fmt.Errorf("%s subscribe %s", s.name, err))
-				}
+	channel, err := connection.Channel()
+	if err != nil {
+		return c, fmt.Errorf("publisher %s", err)
+	}
 
-			case a := <-r.rabbit.publish:
-				if err := r.publish(a, publisher); err != nil {
-					log.Error(rtag, fmt.Errorf("%s publish %s", a.ID, err))
-				}
-			}
+	c.channels = append(c.channels, channel)
+	if err = channel.Confirm(false); err != nil {
+		return c, fmt.Errorf("publisher confirms %s", err)
+	}
+
+	// events exchange used to be declared as not durable, broker which still
+	// has it refuses durable declaration, it has to be deleted once, with
+	// rabbitmqctl delete_exchange events (or from management UI), before
+	// services using durable exchange are started.
+	if err = channel.ExchangeDeclare("events", "topic", true, false, false, false, nil); err != nil {
+		if e, ok := err.(*amqp.Error); ok && e.Code == amqp.PreconditionFailed {
+			return c, fmt.Errorf("publisher %s, events exchange exists as not durable, it has to be deleted to be declared as durable", err)
 		}
+
+		return c, fmt.Errorf("publisher %s", err)
 	}
 
-	errors := func() {
+	if r.deadLetters != "" {
+		err = channel.ExchangeDeclare(r.deadLetters, "direct", true, false, false, false, nil)
+		if err != nil {
+			return c, fmt.Errorf("dead letters %s", err)
+		}
+	}
 
-		for err := range r.errors {
-			attempts++
-			wait := time.Second
-			if attempts > 10 {
-				wait = time.Second * 3
-			}
+	c.publisher = &rabbitPublisher{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  channel.NotifyReturn(make(chan amqp.Return, 16)),
+		returned: make(map[string]amqp.Return),
+	}
+	log.Debug(rtag, "publish channel established")
+
+	if !r.channels {
+		if c.subscriber, err = r.channel(c); err != nil {
+			return c, err
+		}
+		log.Debug(rtag, "subscribe channel established")
+	}
+
+	return c, nil
+}
+
+// resubscribe consumes subscriptions after reconnection, returning these which
+// were consumed, rejected ones are dropped, so they do not break next
+// reconnections.
+func (c *rabbitConnection) resubscribe(r *rabbitMQ, ss []Subscription) []Subscription {
+	var ok []Subscription
+	for _, s := range ss {
+		if err := c.subscribe(r, s); err != nil {
+			log.Error(rtag, fmt.Errorf("%s subscribe %s, subscription dropped", s.name, err))
+			continue
+		}
+
+		ok = append(ok, s)
+	}
+
+	return ok
+}
 
-			log.Error(rtag, fmt.Errorf("%s, reconnect #%d after %s", err, attempts, wait))
-			time.Sleep(wait)
+// disconnect cancels consumers and closes connection. When wait is set, it
+// blocks until handlers are done with delivered events, on lost connection
+// they can not acknowledge them anyway.
+func (r *rabbitMQ) disconnect(c *rabbitConnection, wait bool) {
+	if c == nil {
+		return
+	}
 
-			r.rabbit.connect <- fmt.Sprintf("reconnection #%d", attempts)
+	for _, s := range c.consumers {
+		if err := s.channel.Cancel(s.tag, false); err != nil {
+			log.Debug(rtag, "%s cancel %s", s.tag, err)
 		}
 	}
 
-	go rabbit()
-	go subscriber()
-	go errors()
+	if wait {
+		r.handlers.Wait()
+	}
 
-	r.rabbit.connect <- "connecting"
+	for _, ch := range c.channels {
+		ch.Close()
+	}
 
-	return r, <-r.finish
+	if err := c.connection.Close(); err != nil {
+		log.Debug(rtag, "disconnecting %s", err)
+	}
 }
 
-func (r *rabbitMQ) Publish(a AggregateEvents) error {
-	r.rabbit.publish <- a
-	return nil
+func (r *rabbitMQ) channel(c *rabbitConnection) (*amqp.Channel, error) {
+	ch, err := c.connection.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("channel %s", err)
+	}
+
+	c.channels = append(c.channels, ch)
+	if r.prefetch > 0 {
+		if err = ch.Qos(r.prefetch, 0, false); err != nil {
+			return nil, fmt.Errorf("channel qos %s", err)
+		}
+	}
+
+	return ch, nil
 }
 
-func (r *rabbitMQ) Subscribe(s Subscription) error {
-	r.subscribers.assign <- s
+// subscribe declares queues and bindings of Subscription on its own channel,
+// since broker closes channel which declaration it rejects, then consumes them
+// on subscriber channel.
+func (c *rabbitConnection) subscribe(r *rabbitMQ, s Subscription) error {
+	ch := c.subscriber
+	if r.channels {
+		var err error
+		if ch, err = r.channel(c); err != nil {
+			return err
+		}
+	}
+
+	consume := r.consume
+	if s.group != "" {
+		if err := groups(c.connection); err != nil {
+			return fmt.Errorf("%s group %s", s.group, err)
+		}
+
+		consume = r.consumeGroup
+	}
+
+	d, err := c.connection.Channel()
+	if err != nil {
+		return fmt.Errorf("declaring channel %s", err)
+	}
+
+	defer d.Close()
+
+	tags, err := consume(s, d, ch, fmt.Sprintf("%s.%d", s.name, len(c.consumers)+1))
+	for _, tag := range tags {
+		c.consumers = append(c.consumers, rabbitConsumer{tag: tag, channel: ch})
+	}
+
+	return err
+}
+
+// flush publishes buffered events in order, returning these which could not be
+// sent because of lost connection.
+func (r *rabbitMQ) flush(pp []publishing, p *rabbitPublisher) []publishing {
+	for len(pp) > 0 {
+		err := r.publishEvents(&pp[0], p)
+		if err == errDisconnected {
+			return pp
+		}
+
+		if err != nil {
+			log.Error(rtag, fmt.Errorf("%s.%s publish %s", pp[0].events.ID, pp[0].events.Type, err))
+		}
+
+		pp[0].done <- err
+		pp = pp[1:]
+	}
 
 	return nil
 }
 
-func (r *rabbitMQ) publish(a AggregateEvents, p *amqp.Channel) error {
+// publishEvents sends events which were not confirmed yet, one by one.
+func (r *rabbitMQ) publishEvents(pp *publishing, p *rabbitPublisher) error {
 	if p == nil {
-		return fmt.Errorf("empty publisher channel")
+		return errDisconnected
 	}
 
-	for _, e := range a.Events {
-		b, err := json.Marshal(e)
+	a := pp.events
+	for _, e := range a.Events[pp.sent:] {
+		name, m, err := r.encode(a.Aggregate, e)
 		if err != nil {
 			return err
 		}
 
-		name := route(a.ID, a.Type, e.Type)
-		err = p.Publish("events", name, true, false, amqp.Publishing{
-			ContentType: "application/json",
-			Body:        b,
-		})
+		if err = p.channel.Publish("events", name, true, false, m); err != nil {
+			log.Error(rtag, fmt.Errorf("%s publish %s", name, err))
+			return errDisconnected
+		}
 
-		if err != nil {
+		p.tag++
+		if err := r.confirm(name, m.MessageId, p); err != nil {
 			return err
 		}
+
+		pp.sent++
 	}
 
 	return nil
 }
 
-func (r *rabbitMQ) subscribe(s Subscription, c *amqp.Channel) error {
+// confirm waits for broker confirmation of last published message. Message
+// which was not routed to any queue is returned by broker before it is
+// confirmed, returns are read while waiting, so broker is never blocked by
+// them, and matched with message by its ID.
+func (r *rabbitMQ) confirm(name, id string, p *rabbitPublisher) error {
+	defer func() {
+		for id := range p.returned {
+			delete(p.returned, id)
+		}
+	}()
+
+	timeout := after(r.timeout)
+	for {
+		select {
+		case m, ok := <-p.returns:
+			if !ok {
+				p.returns = nil
+				continue
+			}
+
+			p.returned[m.MessageId] = m
+
+		case c, ok := <-p.confirms:
+			if !ok {
+				return errDisconnected
+			}
+
+			// confirmation of message which caller stopped waiting for.
+			if c.DeliveryTag < p.tag {
+				continue
+			}
+
+			if !c.Ack {
+				return fmt.Errorf("%s rejected by broker", name)
+			}
+
+			m, ok := p.returned[id]
+			if !ok {
+				return nil
+			}
+
+			if r.mandatory {
+				return fmt.Errorf("%s unroutable: %s", m.RoutingKey, m.ReplyText)
+			}
+
+			log.Debug(rtag, "%s returned, there is no subscription for it", m.RoutingKey)
+			return nil
+
+		case <-timeout:
+			return fmt.Errorf("%s confirmation timeout after %s", name, r.timeout)
+
+		// publishing is failed with error of closed pubsub.
+		case <-r.ctx.Done():
+			return errDisconnected
+		}
+	}
+}
+
+// consume declares queue of Subscription with d channel, and consumes it with
+// c channel.
+func (r *rabbitMQ) consume(s Subscription, d, c *amqp.Channel, tag string) ([]string, error) {
 	if c == nil {
-		return fmt.Errorf("empty publisher channel")
+		return nil, fmt.Errorf("empty subscriber channel")
 	}
 
-	queue, err := c.QueueDeclare(s.name, false, true, false, true, nil)
+	// named subscription survives restarts of broker and subscriber, anonymous
+	// one lives as long as connection.
+	durable := s.name != ""
+	args := amqp.Table{}
+	if durable {
+		if err := r.deadLettersOf(s.name, d, args); err != nil {
+			return nil, err
+		}
+	}
+
+	queue, err := d.QueueDeclare(s.name, durable, !durable, !durable, false, args)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var l string
 	for _, route := range s.routes {
-		if err := c.QueueBind(queue.Name, route, "events", true, nil); err != nil {
-			return err
+		if err := d.QueueBind(queue.Name, route, "events", false, nil); err != nil {
+			return nil, err
 		}
 
 		l += fmt.Sprintf("%s\n\t", route)
 	}
 	log.Debug(rtag, "%s bind to\n\t%s", queue.Name, l)
 
-	msg, err := c.Consume(queue.Name, "", true, false, false, true, nil)
+	msg, err := c.Consume(queue.Name, tag, false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r.handlers.Add(1)
+	go r.deliver(s, queue.Name, msg)
+
+	return []string{tag}, nil
+}
+
+// consumeGroup routes events of Subscription group through consistent hash
+// exchange, partitioning them by aggregate ID into RabbitMQPartitions queues.
+// Every member consumes all partitions, but only one of them is active
+// consumer of a partition, so events of aggregate are handled in order. Member
+// priority differs between partitions, active consumer of partition is member
+// with highest priority, so partitions are spread among members and they are
+// rebalanced when member joins or leaves. Priorities are hashes of member
+// name and partition (rendezvous hashing), so members do not tie on partition
+// and they get about the same number of partitions. It requires RabbitMQ 4.0 or newer,
+// where single active consumer of quorum queue follows consumer priorities,
+// with rabbitmq_consistent_hash_exchange plugin enabled.
+func (r *rabbitMQ) consumeGroup(s Subscription, d, c *amqp.Channel, tag string) ([]string, error) {
+	if c == nil {
+		return nil, fmt.Errorf("empty subscriber channel")
+	}
+
+	exchange := "events." + s.group
+	err := d.ExchangeDeclare(exchange, "x-consistent-hash", true, false, false, false, amqp.Table{
+		"hash-header": "aggregate-id",
+	})
+
+	if e, ok := err.(*amqp.Error); ok && e.Code == amqp.CommandInvalid {
+		return nil, fmt.Errorf("%s, rabbitmq_consistent_hash_exchange plugin has to be enabled", err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, route := range s.routes {
+		if err := d.ExchangeBind(exchange, route, "events", false, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var tags []string
+	for i := 0; i < r.partitions; i++ {
+		args := amqp.Table{
+			"x-queue-type":             "quorum",
+			"x-single-active-consumer": true,
+		}
+
+		if err := r.deadLettersOf(s.group, d, args); err != nil {
+			return tags, err
+		}
+
+		queue, err := d.QueueDeclare(fmt.Sprintf("%s.%d", s.group, i), true, false, false, false, args)
+		if err != nil {
+			return tags, err
+		}
+
+		// weight of partition in consistent hash exchange.
+		if err := d.QueueBind(queue.Name, "1", exchange, false, nil); err != nil {
+			return tags, err
+		}
+
+		t := fmt.Sprintf("%s.%d", tag, i)
+		msg, err := c.Consume(queue.Name, t, false, false, false, false, amqp.Table{
+			"x-priority": priority(s.name, i),
+		})
+
+		if err != nil {
+			return tags, err
+		}
+
+		tags = append(tags, t)
+		r.handlers.Add(1)
+		go r.deliver(s, queue.Name, msg)
+	}
+
+	log.Debug(rtag, "%s consumes %d partitions of %s group", s.name, r.partitions, s.group)
+	return tags, nil
+}
+
+// priority of group member on partition, positive 31 bits hash, so priorities
+// of members are distinct in practice. Hash is mixed, so names which differ
+// in last characters get unrelated priorities.
+func priority(member string, partition int) int32 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s.%d", member, partition)
+
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+
+	return int32(x >> 1)
+}
+
+// groups checks if broker supports consumer groups, older brokers do not switch
+// single active consumer to one with higher priority, so partitions would never
+// be rebalanced.
+func groups(c *amqp.Connection) error {
+	v, _ := c.Properties["version"].(string)
+	major, err := strconv.Atoi(strings.SplitN(v, ".", 2)[0])
+	if err != nil {
+		log.Info(rtag, "unknown broker version %q, consumer groups require RabbitMQ 4.0 or newer", v)
+		return nil
+	}
+
+	if major < 4 {
+		return fmt.Errorf("requires RabbitMQ 4.0 or newer, broker is %s", v)
+	}
+
+	return nil
+}
+
+// deadLettersOf declares "<name>.dead" queue and sets queue arguments, so
+// rejected events are routed into it, when RabbitMQDeadLetters is enabled.
+func (r *rabbitMQ) deadLettersOf(name string, c *amqp.Channel, args amqp.Table) error {
+	if r.deadLetters == "" {
+		return nil
+	}
+
+	args["x-dead-letter-exchange"] = r.deadLetters
+	args["x-dead-letter-routing-key"] = name
+
+	dead, err := c.QueueDeclare(name+".dead", true, false, false, false, nil)
 	if err != nil {
 		return err
 	}
 
-	go func(deliveries <-chan amqp.Delivery) {
-		for d := range deliveries {
-			e := Event{}
-			if err := json.Unmarshal(d.Body, &e); err != nil {
-				log.Error(fmt.Sprintf("%s.%s", rtag, queue.Name), err)
-				continue
+	return c.QueueBind(dead.Name, name, r.deadLetters, false, nil)
+}
+
+func (r *rabbitMQ) deliver(s Subscription, queue string, deliveries <-chan amqp.Delivery) {
+	defer r.handlers.Done()
+
+	// failures of messages handled by this consumer, redelivered flag is not
+	// used, since it is also set on messages which were delivered to consumer
+	// which crashed before handling them.
+	failures := make(map[string]int)
+	for d := range deliveries {
+		id := d.MessageId
+		if id == "" {
+			id = d.RoutingKey
+		}
+
+		a, e, err := r.decode(d)
+		if err != nil {
+			log.Error(fmt.Sprintf("%s.%s", rtag, queue), err)
+			r.reject(queue, d, false)
+			continue
+		}
+
+		if err := s.handler(a, e); err != nil {
+			// first failure is requeued, when it happens again, event goes
+			// to dead letters. Without dead letters it is requeued until
+			// handler succeeds, or until delivery limit of quorum queue.
+			failures[id]++
+			requeue := r.requeue && (r.deadLetters == "" || failures[id] < 2)
+			if !requeue {
+				delete(failures, id)
 			}
 
-			rk := strings.Split(d.RoutingKey, ".")
-			a := Aggregate{
-				ID:   rk[0],
-				Type: rk[1]}
+			log.Error(rtag, fmt.Errorf("%s %s.%s.%s[v.%d] rejected, requeue %t: %s", queue, a.ID, a.Type, e.Type, e.Version, requeue, err))
+			if requeue {
+				r.wait(failures[id])
+			}
+
+			r.reject(queue, d, requeue)
+			continue
+		}
 
-			s.handler(a, e)
+		delete(failures, id)
 
-			log.Debug(rtag, "%s %s.%s.%s[v.%d] delivered", queue.Name, a.ID, a.Type, e.Type, e.Version)
+		if err := d.Ack(false); err != nil {
+			log.Error(rtag, fmt.Errorf("%s ack %s", queue, err))
+			continue
 		}
-	}(msg)
 
-	return nil
+		log.Debug(rtag, "%s %s.%s.%s[v.%d] delivered", queue, a.ID, a.Type, e.Type, e.Version)
+	}
+}
+
+// encode event into message, which carries aggregate and event details in body
+// and headers, routed by key with escaped words.
+func (r *rabbitMQ) encode(a Aggregate, e Event) (string, amqp.Publishing, error) {
+	b, err := r.serializer.Marshal(envelope{Aggregate: a, Event: e})
+	if err != nil {
+		return "", amqp.Publishing{}, err
+	}
+
+	key := route(a.ID, a.Type, e.Type)
+	if len(key) > maxRoutingKey {
+		return "", amqp.Publishing{}, fmt.Errorf("%s.%s.%s routing key has %d bytes after escaping, AMQP allows %d", a.ID, a.Type, e.Type, len(key), maxRoutingKey)
+	}
+
+	return key, amqp.Publishing{
+		Headers: amqp.Table{
+			"aggregate-id":   a.ID,
+			"aggregate-type": a.Type,
+			"event":          e.Type,
+			"version":        int64(e.Version),
+		},
+		ContentType:  r.contentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    fmt.Sprintf("%s.%d", key, e.Version),
+		Timestamp:    e.CreatedAt,
+		Type:         e.Type,
+		Body:         b,
+	}, nil
+}
+
+func (r *rabbitMQ) decode(d amqp.Delivery) (Aggregate, Event, error) {
+	// messages published before envelope was introduced, carry only Event,
+	// their Aggregate is taken from routing key.
+	var m envelope
+	if err := r.serializer.Unmarshal(d.Body, &m); err != nil {
+		return m.Aggregate, m.Event, err
+	}
+
+	if m.Aggregate.Type != "" {
+		return m.Aggregate, m.Event, nil
+	}
+
+	id, ok := d.Headers["aggregate-id"].(string)
+	typ, _ := d.Headers["aggregate-type"].(string)
+	if ok && typ != "" {
+		m.Aggregate = Aggregate{ID: id, Type: typ}
+		return m.Aggregate, m.Event, nil
+	}
+
+	rk := strings.Split(d.RoutingKey, ".")
+	if len(rk) < 3 {
+		return m.Aggregate, m.Event, fmt.Errorf("%s routing key does not describe aggregate", d.RoutingKey)
+	}
+
+	m.Aggregate = Aggregate{
+		ID:   unescape(rk[0]),
+		Type: unescape(rk[1])}
+
+	return m.Aggregate, m.Event, nil
+}
+
+// wait before requeue of event which failed given number of times, so failing
+// handler is not called in a loop. Waiting stops when pubsub is closed.
+func (r *rabbitMQ) wait(failures int) {
+	d := r.retryDelay * time.Duration(failures)
+	if d > time.Minute {
+		d = time.Minute
+	}
+
+	select {
+	case <-after(d):
+	case <-r.ctx.Done():
+	}
+}
+
+func (r *rabbitMQ) reject(queue string, d amqp.Delivery, requeue bool) {
+	if err := d.Nack(false, requeue); err != nil {
+		log.Error(rtag, fmt.Errorf("%s nack %s", queue, err))
+	}
+}
+
+type RabbitMQOption func(*rabbitMQ)
+
+// RabbitMQPrefetch limits number of unacknowledged deliveries which RabbitMQ
+// sends to each subscription consumer.
+func RabbitMQPrefetch(n int) RabbitMQOption {
+	return func(r *rabbitMQ) { r.prefetch = n }
+}
+
+// RabbitMQRequeue decides if event which subscription handler failed to
+// process is requeued. Enabled by default, then event is requeued once before
+// it goes to RabbitMQDeadLetters, without dead letters it is requeued until
+// it is handled. Quorum queues of Subscription group drop such event after
+// their delivery limit, 20 by default on RabbitMQ 4.0, so groups should use
+// dead letters. Disabled requeue drops such event or sends it to dead letters
+// at once.
+func RabbitMQRequeue(requeue bool) RabbitMQOption {
+	return func(r *rabbitMQ) { r.requeue = requeue }
+}
+
+// RabbitMQRetryDelay sets delay before failed event is requeued, multiplied by
+// number of its failures, up to a minute. Default is 1 second.
+func RabbitMQRetryDelay(d time.Duration) RabbitMQOption {
+	return func(r *rabbitMQ) { r.retryDelay = d }
+}
+
+// RabbitMQDeadLetters routes events rejected by handler of named subscription
+// through given exchange into "<subscription name>.dead" queue, instead of
+// dropping them.
+func RabbitMQDeadLetters(exchange string) RabbitMQOption {
+	return func(r *rabbitMQ) { r.deadLetters = exchange }
+}
+
+// RabbitMQMandatory makes Publish fail when any published event is not routed
+// to a queue. By default such events are only logged.
+func RabbitMQMandatory(mandatory bool) RabbitMQOption {
+	return func(r *rabbitMQ) { r.mandatory = mandatory }
+}
+
+// RabbitMQPublishTimeout limits time Publish waits for broker confirmation,
+// zero means waiting without limit. Default is 10 seconds.
+func RabbitMQPublishTimeout(d time.Duration) RabbitMQOption {
+	return func(r *rabbitMQ) { r.timeout = d }
+}
+
+// RabbitMQBuffer limits number of publishings buffered while connection is
+// lost, when buffer is full Publish fails at once. Default is 1000.
+func RabbitMQBuffer(n int) RabbitMQOption {
+	return func(r *rabbitMQ) { r.buffer = n }
+}
+
+// RabbitMQChannelPerSubscription consumes every subscription on its own AMQP
+// channel, so slow subscription does not hold back deliveries of others. By
+// default all subscriptions share one channel.
+func RabbitMQChannelPerSubscription() RabbitMQOption {
+	return func(r *rabbitMQ) { r.channels = true }
+}
+
+// RabbitMQPartitions sets number of queues, events of Subscription group are
+// partitioned into. It limits number of members actively consuming events, so
+// it has to be the same for all members. Default is 8.
+func RabbitMQPartitions(n int) RabbitMQOption {
+	return func(r *rabbitMQ) { r.partitions = n }
+}
+
+// RabbitMQReconnect replaces default strategy, which reconnects every second
+// and every 3 seconds after 10th attempt, never giving up.
+func RabbitMQReconnect(s ReconnectStrategy) RabbitMQOption {
+	return func(r *rabbitMQ) { r.reconnect = s }
+}
+
+// RabbitMQSerializer changes encoding of events in message body, JSON is used
+// by default.
+func RabbitMQSerializer(contentType string, s Serializer) RabbitMQOption {
+	return func(r *rabbitMQ) { r.contentType, r.serializer = contentType, s }
+}
+
+func reconnect(attempt int, _ error) (time.Duration, bool) {
+	if attempt > 10 {
+		return time.Second * 3, true
+	}
+
+	return time.Second, true
+}
+
+// after returns channel receiving value after d duration, or nil channel which
+// blocks forever, when d is zero.
+func after(d time.Duration) <-chan time.Time {
+	if d <= 0 {
+		return nil
+	}
+
+	return time.After(d)
 }
@@ -0,0 +1,399 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sokool/gokit/log"
+)
+
+const rdtag = "es.redis"
+
+// redisPubSub publishes events into Redis Streams, one stream per aggregate
+// type. Subscription is a consumer group of these streams, so many instances
+// subscribed under the same name share events between them. Event which
+// handler failed to process is retried before next events are read, so events
+// of aggregate are handled in order by single consumer of group, after
+// RedisMaxDeliver attempts it is moved into dead letters stream. Consumers of
+// the same group read events of the same streams concurrently, so events of
+// aggregate are handled in order only, when one instance is subscribed under
+// given name.
+type redisPubSub struct {
+	client      redis.UniversalClient
+	prefix      string
+	consumer    string
+	maxLen      int64
+	count       int64
+	block       time.Duration
+	minIdle     time.Duration
+	maxDeliver  int
+	retryDelay  time.Duration
+	deadLetters string
+	// deadLetters were set by option, otherwise they are derived from prefix.
+	deadSet    bool
+	serializer Serializer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	anonymous map[string][]string
+}
+
+func NewRedisPubSub(c redis.UniversalClient, oo ...RedisOption) *redisPubSub {
+	host, _ := os.Hostname()
+	r := &redisPubSub{
+		client:     c,
+		prefix:     "events",
+		consumer:   fmt.Sprintf("%s.%d", host, os.Getpid()),
+		count:      100,
+		block:      5 * time.Second,
+		minIdle:    time.Minute,
+		maxDeliver: 5,
+		retryDelay: time.Second,
+		serializer: jsonSerializer{},
+		anonymous:  make(map[string][]string),
+	}
+
+	for _, o := range oo {
+		o(r)
+	}
+
+	if !r.deadSet {
+		r.deadLetters = r.prefix + "-dead"
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+// Publish appends events to stream of aggregate type in one transaction,
+// trimming stream to configured length. It works also after Close, which stops
+// only subscriptions.
+func (r *redisPubSub) Publish(a AggregateEvents) error {
+	ctx := context.Background()
+	_, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for _, e := range a.Events {
+			b, err := r.serializer.Marshal(envelope{Aggregate: a.Aggregate, Event: e})
+			if err != nil {
+				return err
+			}
+
+			p.XAdd(ctx, &redis.XAddArgs{
+				Stream: r.stream(a.Type),
+				MaxLen: r.maxLen,
+				Approx: true,
+				Values: map[string]interface{}{
+					"aggregate_id":   a.ID,
+					"aggregate_type": a.Type,
+					"event":          e.Type,
+					"version":        e.Version,
+					"envelope":       b,
+				},
+			})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("%s.%s publish %s", a.ID, a.Type, err)
+	}
+
+	return nil
+}
+
+// Subscribe creates consumer group, reading events published since now. Named
+// Subscription continues from last acknowledged event, when group exists,
+// starting with events pending for this consumer. Events left pending by
+// consumer which crashed are claimed after RedisMinIdle, by any consumer of
+// group. Events are kept in streams of aggregate types, so Subscription of
+// all aggregates ("*") is rejected.
+func (r *redisPubSub) Subscribe(s Subscription) error {
+	group := s.name
+	if group == "" {
+		group = fmt.Sprintf("%s.%d", r.consumer, time.Now().UnixNano())
+	}
+
+	if _, ok := s.subscriptions["*"]; ok {
+		return fmt.Errorf("%s subscribes all aggregates, redis streams require aggregate types", group)
+	}
+
+	var streams []string
+	for aggregate := range s.subscriptions {
+		stream := r.stream(aggregate)
+		err := r.client.XGroupCreateMkStream(r.ctx, stream, group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("%s group %s", group, err)
+		}
+
+		streams = append(streams, stream)
+	}
+
+	if len(streams) == 0 {
+		return fmt.Errorf("%s subscribes no aggregate", group)
+	}
+
+	if s.name == "" {
+		r.mu.Lock()
+		r.anonymous[group] = streams
+		r.mu.Unlock()
+	}
+
+	r.wg.Add(1)
+	go r.read(s, group, streams)
+
+	log.Debug(rdtag, "%s consumes %v", group, streams)
+	return nil
+}
+
+// Close stops reading, waits until handlers process delivered events and
+// removes groups of anonymous subscriptions.
+func (r *redisPubSub) Close() error {
+	r.cancel()
+	r.wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for group, streams := range r.anonymous {
+		for _, stream := range streams {
+			if err := r.client.XGroupDestroy(context.Background(), stream, group).Err(); err != nil {
+				log.Error(rdtag, fmt.Errorf("%s destroy %s", group, err))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *redisPubSub) read(s Subscription, group string, streams []string) {
+	defer r.wg.Done()
+
+	args := &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: r.consumer,
+		Count:    r.count,
+		Block:    r.block,
+	}
+
+	// events pending for this consumer since previous run are read first,
+	// from given IDs, then new ones.
+	pending := make(map[string]string)
+	for _, stream := range streams {
+		pending[stream] = "0"
+	}
+
+	claimed := time.Now()
+	for r.ctx.Err() == nil {
+		if time.Since(claimed) >= r.minIdle {
+			for _, stream := range streams {
+				r.reclaim(s, group, stream)
+			}
+			claimed = time.Now()
+		}
+
+		args.Streams = append(args.Streams[:0], streams...)
+		for _, stream := range streams {
+			id := ">"
+			if len(pending) > 0 {
+				id = pending[stream]
+			}
+			args.Streams = append(args.Streams, id)
+		}
+
+		res, err := r.client.XReadGroup(r.ctx, args).Result()
+		if err == redis.Nil || r.ctx.Err() != nil {
+			continue
+		}
+
+		if err != nil {
+			log.Error(rdtag, fmt.Errorf("%s read %s", group, err))
+			select {
+			case <-time.After(time.Second):
+			case <-r.ctx.Done():
+			}
+			continue
+		}
+
+		var n int
+		for _, x := range res {
+			for _, m := range x.Messages {
+				r.deliver(s, group, x.Stream, m)
+				if len(pending) > 0 {
+					pending[x.Stream] = m.ID
+				}
+				n++
+			}
+		}
+
+		if len(pending) > 0 && n == 0 {
+			pending = nil
+		}
+	}
+}
+
+// reclaim takes over events pending longer than minIdle, which were delivered
+// to consumer which crashed or was closed while retrying them.
+func (r *redisPubSub) reclaim(s Subscription, group, stream string) {
+	start := "0-0"
+	for r.ctx.Err() == nil {
+		mm, next, err := r.client.XAutoClaim(r.ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			Consumer: r.consumer,
+			MinIdle:  r.minIdle,
+			Start:    start,
+			Count:    r.count,
+		}).Result()
+
+		if err != nil {
+			log.Error(rdtag, fmt.Errorf("%s claim %s", group, err))
+			return
+		}
+
+		for _, m := range mm {
+			r.deliver(s, group, stream, m)
+		}
+
+		if next == "0-0" || len(mm) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+// deliver event to handler, failed one is retried in place, so next events are
+// not handled before it. After RedisMaxDeliver attempts it is moved to dead
+// letters. Event which is retried when subscriber is closed stays pending.
+func (r *redisPubSub) deliver(s Subscription, group, stream string, m redis.XMessage) {
+	var e envelope
+	b, _ := m.Values["envelope"].(string)
+	if err := r.serializer.Unmarshal([]byte(b), &e); err != nil {
+		log.Error(rdtag, fmt.Errorf("%s %s %s", group, m.ID, err))
+		r.bury(group, stream, m, err)
+		return
+	}
+
+	events := s.subscriptions[e.Aggregate.Type]
+	if len(events) > 0 && !events[e.Type] {
+		r.ack(group, stream, m.ID)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.handler(e.Aggregate, e.Event)
+		if err == nil {
+			r.ack(group, stream, m.ID)
+			return
+		}
+
+		log.Error(rdtag, fmt.Errorf("%s %s.%s.%s[v.%d] attempt #%d: %s", group, e.Aggregate.ID, e.Aggregate.Type, e.Type, e.Version, attempt, err))
+		if r.maxDeliver > 0 && attempt >= r.maxDeliver {
+			r.bury(group, stream, m, err)
+			return
+		}
+
+		select {
+		case <-time.After(r.retryDelay * time.Duration(attempt)):
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// bury moves message into dead letters stream and acknowledges it, without
+// dead letters message is dropped. Message which could not be moved stays
+// pending.
+func (r *redisPubSub) bury(group, stream string, m redis.XMessage, err error) {
+	if r.deadLetters == "" {
+		log.Error(rdtag, fmt.Errorf("%s %s %s dropped: %s", group, stream, m.ID, err))
+		r.ack(group, stream, m.ID)
+		return
+	}
+
+	values := map[string]interface{}{
+		"group":  group,
+		"stream": stream,
+		"id":     m.ID,
+		"error":  err.Error(),
+	}
+
+	for k, v := range m.Values {
+		values[k] = v
+	}
+
+	if err := r.client.XAdd(context.Background(), &redis.XAddArgs{Stream: r.deadLetters, Values: values}).Err(); err != nil {
+		log.Error(rdtag, fmt.Errorf("%s %s %s not moved to dead letters: %s", group, stream, m.ID, err))
+		return
+	}
+
+	log.Info(rdtag, "%s %s %s moved to dead letters", group, stream, m.ID)
+	r.ack(group, stream, m.ID)
+}
+
+func (r *redisPubSub) ack(group, stream, id string) {
+	if err := r.client.XAck(context.Background(), stream, group, id).Err(); err != nil {
+		log.Error(rdtag, fmt.Errorf("%s ack %s %s", group, id, err))
+	}
+}
+
+func (r *redisPubSub) stream(aggregate string) string {
+	return r.prefix + ":" + aggregate
+}
+
+type RedisOption func(*redisPubSub)
+
+// RedisPrefix of stream keys, default is "events".
+func RedisPrefix(p string) RedisOption {
+	return func(r *redisPubSub) { r.prefix = p }
+}
+
+// RedisConsumer names this instance in consumer groups, default is
+// "<hostname>.<pid>". It should be stable between restarts, so pending events
+// of previous run are delivered to the same consumer.
+func RedisConsumer(name string) RedisOption {
+	return func(r *redisPubSub) { r.consumer = name }
+}
+
+// RedisMaxLen trims streams to about given number of events, zero keeps
+// all of them. Trimmed events are lost for groups which did not read them.
+func RedisMaxLen(n int64) RedisOption {
+	return func(r *redisPubSub) { r.maxLen = n }
+}
+
+// RedisMinIdle is time after which pending event is claimed by another
+// consumer. Default is one minute.
+func RedisMinIdle(d time.Duration) RedisOption {
+	return func(r *redisPubSub) { r.minIdle = d }
+}
+
+// RedisMaxDeliver limits number of attempts of handling event, before it is
+// moved to dead letters, zero retries it until it is handled. Default is 5.
+func RedisMaxDeliver(n int) RedisOption {
+	return func(r *redisPubSub) { r.maxDeliver = n }
+}
+
+// RedisRetryDelay is delay of next attempt of handling failed event,
+// multiplied by number of attempts. Default is 1 second.
+func RedisRetryDelay(d time.Duration) RedisOption {
+	return func(r *redisPubSub) { r.retryDelay = d }
+}
+
+// RedisDeadLetters sets key of stream, where events which handler failed to
+// process are moved. Default is "<prefix>-dead", empty key drops them.
+func RedisDeadLetters(stream string) RedisOption {
+	return func(r *redisPubSub) { r.deadLetters, r.deadSet = stream, true }
+}
+
+// RedisSerializer changes encoding of events in streams, JSON is used by
+// default.
+func RedisSerializer(s Serializer) RedisOption {
+	return func(r *redisPubSub) { r.serializer = s }
+}
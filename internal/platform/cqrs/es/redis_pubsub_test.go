@@ -0,0 +1,124 @@
+package es_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestRedisPubSubOrder(t *testing.T) {
+	c := redisClient(t)
+	events := make(chan es.AggregateEvents, 16)
+
+	var failures int
+	r := es.NewRedisPubSub(c, es.RedisRetryDelay(time.Millisecond), es.RedisConsumer("test"))
+	s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+		if e.Type == "Created" && failures < 2 {
+			failures++
+			return fmt.Errorf("database is down")
+		}
+
+		return collect(events)(a, e)
+	})
+
+	if err := r.Subscribe(*s.Name("books").AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, r, "dune", "Book", "Created", "Renamed")
+	expect(t, events, "dune.Book.Created")
+	expect(t, events, "dune.Book.Renamed")
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, r, "dune", "Book", "Archived")
+}
+
+func TestRedisDeadLetters(t *testing.T) {
+	c := redisClient(t)
+	events := make(chan es.AggregateEvents, 16)
+
+	r := es.NewRedisPubSub(c, es.RedisMaxDeliver(3), es.RedisRetryDelay(time.Millisecond))
+	t.Cleanup(func() { r.Close() })
+
+	var attempts int
+	s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+		if e.Type == "Created" {
+			attempts++
+			return fmt.Errorf("database is down")
+		}
+
+		return collect(events)(a, e)
+	})
+
+	if err := r.Subscribe(*s.Name("books").AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, r, "dune", "Book", "Created", "Renamed")
+	expect(t, events, "dune.Book.Renamed")
+
+	if attempts != 3 {
+		t.Fatalf("3 attempts expected, got %d", attempts)
+	}
+
+	mm, err := c.XRange(context.Background(), "events-dead", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mm) != 1 || mm[0].Values["event"] != "Created" || mm[0].Values["error"] != "database is down" {
+		t.Fatalf("dead letter expected, got %v", mm)
+	}
+
+	p, err := c.XPending(context.Background(), "events:Book", "books").Result()
+	if err != nil || p.Count != 0 {
+		t.Fatalf("no pending events expected, got %v %v", p, err)
+	}
+}
+
+func TestRedisPrefix(t *testing.T) {
+	c := redisClient(t)
+	events := make(chan es.AggregateEvents, 16)
+
+	r := es.NewRedisPubSub(c, es.RedisPrefix("shelf"), es.RedisMaxDeliver(1))
+	t.Cleanup(func() { r.Close() })
+
+	s := es.NewSubscription(func(a es.Aggregate, e es.Event) error {
+		if e.Type == "Created" {
+			return fmt.Errorf("database is down")
+		}
+
+		return collect(events)(a, e)
+	})
+
+	if err := r.Subscribe(*es.NewSubscription(collect(events)).AggregateEvents("*")); err == nil {
+		t.Fatal("subscription of all aggregates rejected expected")
+	}
+
+	if err := r.Subscribe(*s.Name("books").AggregateEvents("Book")); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, r, "dune", "Book", "Created", "Renamed")
+	expect(t, events, "dune.Book.Renamed")
+
+	if n, err := c.XLen(context.Background(), "shelf-dead").Result(); err != nil || n != 1 {
+		t.Fatalf("dead letter in stream of prefix expected, got %d %v", n, err)
+	}
+}
+
+func redisClient(t *testing.T) *redis.Client {
+	m := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
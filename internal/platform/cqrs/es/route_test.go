@@ -0,0 +1,132 @@
+package es
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRoute(t *testing.T) {
+	cases := []struct {
+		desc      string
+		id        string
+		aggregate string
+		event     string
+		key       string
+	}{
+		{"plain words", "dune", "Book", "Created", "dune.Book.Created"},
+		{"dots do not split key", "books.dune", "lib.Book", "v1.Created", "books%2Edune.lib%2EBook.v1%2ECreated"},
+		{"wildcards are escaped", "#", "a*b", "*x", "%23.a%2Ab.%2Ax"},
+		{"percent is escaped first", "%2E", "100%", "Created", "%252E.100%25.Created"},
+		{"star word is wildcard", "*", "Book", "*", "*.Book.*"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			key := route(c.id, c.aggregate, c.event)
+			if key != c.key {
+				t.Fatalf("%s key expected, got %s", c.key, key)
+			}
+
+			ww := strings.Split(key, ".")
+			if len(ww) != 3 {
+				t.Fatalf("three words expected, got %v", ww)
+			}
+
+			for i, w := range []string{c.id, c.aggregate, c.event} {
+				if u := unescape(ww[i]); u != w {
+					t.Fatalf("%s word after round trip expected, got %s", w, u)
+				}
+			}
+		})
+	}
+}
+
+func TestRabbitMQEnvelope(t *testing.T) {
+	r := &rabbitMQ{serializer: jsonSerializer{}, contentType: "application/json"}
+	cases := []struct {
+		desc      string
+		aggregate Aggregate
+		event     string
+		err       bool
+	}{
+		{"plain words", Aggregate{ID: "dune", Type: "Book"}, "Created", false},
+		{"escaped words", Aggregate{ID: "a.b#c*", Type: "lib.Book"}, "v1.Created", false},
+		{"key of 255 bytes", Aggregate{ID: strings.Repeat("x", 255-len(".Book.Created")), Type: "Book"}, "Created", false},
+		{"key over 255 bytes", Aggregate{ID: strings.Repeat("x", 256-len(".Book.Created")), Type: "Book"}, "Created", true},
+		{"escaping over 255 bytes", Aggregate{ID: strings.Repeat(".", 100), Type: "Book"}, "Created", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			key, m, err := r.encode(c.aggregate, Event{Type: c.event, Version: 3, Data: []byte(`{}`)})
+			if c.err {
+				if err == nil {
+					t.Fatalf("routing key of %d bytes rejected expected", len(key))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(key) > maxRoutingKey {
+				t.Fatalf("routing key up to %d bytes expected, got %d", maxRoutingKey, len(key))
+			}
+
+			a, e, err := r.decode(amqp.Delivery{RoutingKey: key, Headers: m.Headers, Body: m.Body})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if a != c.aggregate || e.Type != c.event || e.Version != 3 {
+				t.Fatalf("%v %s expected, got %v %s", c.aggregate, c.event, a, e.Type)
+			}
+		})
+	}
+}
+
+func TestRabbitMQDecodeWithoutEnvelope(t *testing.T) {
+	r := &rabbitMQ{serializer: jsonSerializer{}}
+	cases := []struct {
+		desc      string
+		delivery  amqp.Delivery
+		aggregate Aggregate
+		err       bool
+	}{
+		{
+			desc:      "aggregate from headers",
+			delivery:  amqp.Delivery{RoutingKey: "x.y.Created", Headers: amqp.Table{"aggregate-id": "a.b", "aggregate-type": "Book"}},
+			aggregate: Aggregate{ID: "a.b", Type: "Book"},
+		},
+		{
+			desc:      "aggregate from escaped routing key",
+			delivery:  amqp.Delivery{RoutingKey: "a%2Eb%23.lib%2EBook.Created"},
+			aggregate: Aggregate{ID: "a.b#", Type: "lib.Book"},
+		},
+		{
+			desc:     "routing key without aggregate",
+			delivery: amqp.Delivery{RoutingKey: "Created"},
+			err:      true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			c.delivery.Body = []byte(`{"Type":"Created","Version":1}`)
+			a, e, err := r.decode(c.delivery)
+			if c.err {
+				if err == nil {
+					t.Fatal("error expected")
+				}
+				return
+			}
+
+			if err != nil || a != c.aggregate || e.Type != "Created" {
+				t.Fatalf("%v expected, got %v %s %v", c.aggregate, a, e.Type, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package es
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SagaState is persisted state of one saga instance, identified by saga name
+// and correlation ID. Pending commands are outbox of commands decided by saga,
+// but not yet dispatched, Processed keeps last handled version of every
+// aggregate, so redelivered event is not handled twice.
+type SagaState struct {
+	Saga      string
+	ID        string
+	Data      []byte
+	Version   uint
+	Pending   []SagaCommand
+	Processed map[string]uint
+	Done      bool
+	UpdatedAt time.Time
+}
+
+// SagaCommand is command waiting in outbox of saga.
+type SagaCommand struct {
+	ID        string
+	Aggregate string
+	Name      string
+	Data      []byte
+	Meta      []byte
+}
+
+// IsProcessed tells if event of given aggregate and version was handled by
+// saga, events of aggregate are expected in order of their versions.
+func (s SagaState) IsProcessed(aggregate string, version uint) bool {
+	return version <= s.Processed[aggregate]
+}
+
+// SagaStore keeps states of sagas with optimistic concurrency, Save fails with
+// ErrConcurrency when stored version of state is not expected one.
+type SagaStore interface {
+	// Load state of saga instance, false is returned when it does not exist.
+	Load(saga, id string) (SagaState, bool, error)
+	// Save state, which version is expected to be stored, zero means new
+	// state. Version of saved state is expected+1.
+	Save(s SagaState, expected uint) error
+	// Pending lists states of saga with commands in outbox.
+	Pending(saga string) ([]SagaState, error)
+}
+
+type memSagas struct {
+	mu     sync.Mutex
+	states map[string]SagaState
+}
+
+func NewMemSagas() SagaStore {
+	return &memSagas{states: make(map[string]SagaState)}
+}
+
+func (m *memSagas) Load(saga, id string) (SagaState, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.states[saga+"."+id]
+	return s, ok, nil
+}
+
+func (m *memSagas) Save(s SagaState, expected uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := s.Saga + "." + s.ID
+	if p := m.states[k]; p.Version != expected {
+		return fmt.Errorf("%w: %s saga is not in version %d", ErrConcurrency, k, expected)
+	}
+
+	s.Version = expected + 1
+	s.UpdatedAt = time.Now()
+	m.states[k] = s
+
+	return nil
+}
+
+func (m *memSagas) Pending(saga string) ([]SagaState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []SagaState
+	for _, s := range m.states {
+		if s.Saga == saga && len(s.Pending) > 0 {
+			out = append(out, s)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt.Before(out[j].UpdatedAt) })
+	return out, nil
+}
@@ -0,0 +1,125 @@
+package es
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scheduled is command which should be sent to aggregate at given time.
+// Scheduled command is identified by its Key, scheduling command with the same
+// Key replaces previous one.
+type Scheduled struct {
+	Key       string
+	Aggregate string
+	Command   string
+	Data      []byte
+	Meta      []byte
+	At        time.Time
+	Attempts  uint
+	CreatedAt time.Time
+}
+
+// Schedule stores commands waiting for their time.
+type Schedule interface {
+	Schedule(Scheduled) error
+	// Cancel removes command of given key, unknown key is not an error.
+	Cancel(key string) error
+	// Due lists commands which time is not after now, earliest first, and
+	// leases them by moving their time to now+lease, so they are not listed
+	// again, until lease expires.
+	Due(now time.Time, lease time.Duration, limit int) ([]Scheduled, error)
+	// Done removes sent command, unless it was replaced in meantime.
+	Done(Scheduled) error
+	// Retry moves failed command to given time with its Attempts, unless it
+	// was replaced in meantime.
+	Retry(c Scheduled, at time.Time) error
+	// Next returns time of earliest command, false when there is none.
+	Next() (time.Time, bool, error)
+}
+
+type memSchedule struct {
+	mu       sync.Mutex
+	commands map[string]Scheduled
+}
+
+func NewMemSchedule() Schedule {
+	return &memSchedule{commands: make(map[string]Scheduled)}
+}
+
+func (m *memSchedule) Schedule(s Scheduled) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.commands[s.Key] = s
+	return nil
+}
+
+func (m *memSchedule) Cancel(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.commands, key)
+	return nil
+}
+
+func (m *memSchedule) Due(now time.Time, lease time.Duration, limit int) ([]Scheduled, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []Scheduled
+	for _, s := range m.commands {
+		if !s.At.After(now) {
+			out = append(out, s)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+
+	for i := range out {
+		out[i].At = now.Add(lease)
+		m.commands[out[i].Key] = out[i]
+	}
+
+	return out, nil
+}
+
+func (m *memSchedule) Done(s Scheduled) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.commands[s.Key]; ok && c.CreatedAt.Equal(s.CreatedAt) && c.At.Equal(s.At) {
+		delete(m.commands, s.Key)
+	}
+
+	return nil
+}
+
+func (m *memSchedule) Retry(s Scheduled, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.commands[s.Key]; ok && c.CreatedAt.Equal(s.CreatedAt) && c.At.Equal(s.At) {
+		c.At, c.Attempts = at, s.Attempts
+		m.commands[s.Key] = c
+	}
+
+	return nil
+}
+
+func (m *memSchedule) Next() (time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var next time.Time
+	for _, s := range m.commands {
+		if next.IsZero() || s.At.Before(next) {
+			next = s.At
+		}
+	}
+
+	return next, !next.IsZero(), nil
+}
@@ -0,0 +1,458 @@
+package es
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sokool/gokit/log"
+)
+
+const wtag = "es.webhooks"
+
+// webhooks is a Publisher which POSTs events to registered HTTP endpoints.
+// Every endpoint has its own queue, so events are delivered to it in order
+// they were published, and slow endpoint does not hold back others.
+type webhooks struct {
+	client      *http.Client
+	attempts    int
+	backoff     time.Duration
+	maxFailures int
+	history     int
+	buffer      int
+
+	mu        sync.Mutex
+	endpoints map[string]*Webhook
+	// workers are closed when last worker of endpoint url stops, so worker
+	// of endpoint registered again waits until previous one delivers queued
+	// events.
+	workers map[string]chan struct{}
+	wg      sync.WaitGroup
+	done    chan struct{}
+	closed  bool
+}
+
+// Webhook is an endpoint registered in webhooks publisher.
+type Webhook struct {
+	url           string
+	secret        string
+	subscriptions map[string]map[string]bool
+	queue         chan webhookEvent
+
+	mu         sync.Mutex
+	failures   int
+	disabled   bool
+	deliveries []WebhookDelivery
+}
+
+// WebhookDelivery is an entry of endpoint delivery log.
+type WebhookDelivery struct {
+	ID       string
+	Attempt  int
+	Status   int
+	Error    string
+	Duration time.Duration
+	At       time.Time
+}
+
+type webhookEvent struct {
+	Aggregate struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	} `json:"aggregate"`
+	Type      string          `json:"type"`
+	Version   uint            `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+	Meta      json.RawMessage `json:"meta,omitempty"`
+}
+
+func NewWebhookPublisher(oo ...WebhookOption) *webhooks {
+	w := &webhooks{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		attempts:    5,
+		backoff:     time.Second,
+		maxFailures: 10,
+		history:     100,
+		buffer:      1024,
+		endpoints:   make(map[string]*Webhook),
+		workers:     make(map[string]chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	for _, o := range oo {
+		o(w)
+	}
+
+	return w
+}
+
+// Register endpoint, which receives events signed with secret. Use
+// AggregateEvents of returned Webhook to choose events, endpoint without them
+// receives nothing. Endpoint registered again receives events published since
+// then, after events queued for it before are delivered. Endpoint registered
+// after Close receives nothing.
+func (w *webhooks) Register(url, secret string) *Webhook {
+	h := &Webhook{
+		url:           url,
+		secret:        secret,
+		subscriptions: make(map[string]map[string]bool),
+		queue:         make(chan webhookEvent, w.buffer),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		log.Error(wtag, fmt.Errorf("%s not registered, webhooks closed", url))
+		return h
+	}
+
+	if o, ok := w.endpoints[url]; ok {
+		close(o.queue)
+	}
+
+	previous, stopped := w.workers[url], make(chan struct{})
+	w.workers[url] = stopped
+	w.endpoints[url] = h
+	w.wg.Add(1)
+	go w.run(h, previous, stopped)
+
+	return h
+}
+
+// Unregister endpoint, events already queued for it are still delivered.
+func (w *webhooks) Unregister(url string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if h, ok := w.endpoints[url]; ok {
+		close(h.queue)
+		delete(w.endpoints, url)
+	}
+}
+
+// Endpoint returns Webhook registered with given url.
+func (w *webhooks) Endpoint(url string) (*Webhook, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	h, ok := w.endpoints[url]
+	return h, ok
+}
+
+// Publish queues events for every enabled endpoint, which subscribed them.
+// Event is dropped for endpoint which queue is full, what is logged and
+// recorded in its Deliveries, other endpoints still receive it.
+func (w *webhooks) Publish(a AggregateEvents) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, h := range w.endpoints {
+		if !h.Enabled() {
+			continue
+		}
+
+		for _, e := range a.Events {
+			if !h.match(a.Type, e.Type) {
+				continue
+			}
+
+			select {
+			case h.queue <- newWebhookEvent(a.Aggregate, e):
+			default:
+				id := fmt.Sprintf("%s.%d", route(a.ID, a.Type, e.Type), e.Version)
+				log.Error(wtag, fmt.Errorf("%s queue is full, %s dropped", h.url, id))
+				h.record(WebhookDelivery{ID: id, Error: "queue is full, dropped", At: time.Now()}, w.history)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close stops accepting events and waits until queued ones are delivered,
+// failed deliveries are not retried anymore.
+func (w *webhooks) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+	}
+
+	for url, h := range w.endpoints {
+		close(h.queue)
+		delete(w.endpoints, url)
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return nil
+}
+
+// run delivers queued events of endpoint, after previous worker of its url
+// stopped.
+func (w *webhooks) run(h *Webhook, previous <-chan struct{}, stopped chan struct{}) {
+	defer w.wg.Done()
+	defer func() {
+		w.mu.Lock()
+		if w.workers[h.url] == stopped {
+			delete(w.workers, h.url)
+		}
+		w.mu.Unlock()
+		close(stopped)
+	}()
+
+	if previous != nil {
+		<-previous
+	}
+
+	for e := range h.queue {
+		// endpoint disabled after event was queued.
+		if !h.Enabled() {
+			continue
+		}
+
+		if err := w.deliver(h, e); err != nil {
+			h.mu.Lock()
+			h.failures++
+			if w.maxFailures > 0 && h.failures >= w.maxFailures {
+				h.disabled = true
+				log.Error(wtag, fmt.Errorf("%s disabled after %d failed deliveries", h.url, h.failures))
+			}
+			h.mu.Unlock()
+			continue
+		}
+
+		h.mu.Lock()
+		h.failures = 0
+		h.mu.Unlock()
+	}
+}
+
+// deliver event to endpoint, retrying with exponential backoff until webhooks
+// are closed.
+func (w *webhooks) deliver(h *Webhook, e webhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("%s.%d", route(e.Aggregate.ID, e.Aggregate.Type, e.Type), e.Version)
+	wait := w.backoff
+	for attempt := 1; ; attempt++ {
+		status, err := w.post(h, id, attempt, body)
+		if err == nil {
+			return nil
+		}
+
+		retryable := status == 0 || status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+		if attempt >= w.attempts || !retryable {
+			log.Error(wtag, fmt.Errorf("%s %s failed after %d attempts: %s", h.url, id, attempt, err))
+			return err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-w.done:
+			log.Error(wtag, fmt.Errorf("%s %s failed after %d attempts, closed: %s", h.url, id, attempt, err))
+			return err
+		}
+
+		if wait *= 2; wait > time.Minute {
+			wait = time.Minute
+		}
+	}
+}
+
+func (w *webhooks) post(h *Webhook, id string, attempt int, body []byte) (int, error) {
+	d := WebhookDelivery{ID: id, Attempt: attempt, At: time.Now()}
+	defer func() {
+		d.Duration = time.Since(d.At)
+		h.record(d, w.history)
+	}()
+
+	r, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		d.Error = err.Error()
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(d.At.Unix(), 10)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Event-Id", id)
+	r.Header.Set("X-Event-Timestamp", timestamp)
+	r.Header.Set("X-Event-Signature", SignWebhook(h.secret, timestamp, body))
+
+	res, err := w.client.Do(r)
+	if err != nil {
+		d.Error = err.Error()
+		return 0, err
+	}
+
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	d.Status = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err = fmt.Errorf("%s responded %s", h.url, res.Status)
+		d.Error = err.Error()
+		return res.StatusCode, err
+	}
+
+	return res.StatusCode, nil
+}
+
+// AggregateEvents subscribes endpoint to events of aggregate, all of them when
+// events are not given.
+func (h *Webhook) AggregateEvents(aggregate string, events ...string) *Webhook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subscriptions[aggregate] = make(map[string]bool)
+	for _, e := range events {
+		h.subscriptions[aggregate][e] = true
+	}
+
+	return h
+}
+
+func (h *Webhook) Enabled() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !h.disabled
+}
+
+// Enable endpoint disabled after too many failed deliveries. Events published
+// while it was disabled are not delivered.
+func (h *Webhook) Enable() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.disabled, h.failures = false, 0
+}
+
+// Deliveries returns log of last delivery attempts, the oldest first.
+func (h *Webhook) Deliveries() []WebhookDelivery {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]WebhookDelivery(nil), h.deliveries...)
+}
+
+func (h *Webhook) match(aggregate, event string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events, ok := h.subscriptions[aggregate]
+	return ok && (len(events) == 0 || events[event])
+}
+
+func (h *Webhook) record(d WebhookDelivery, history int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.deliveries = append(h.deliveries, d)
+	if n := len(h.deliveries); n > history {
+		h.deliveries = h.deliveries[n-history:]
+	}
+}
+
+func newWebhookEvent(a Aggregate, e Event) webhookEvent {
+	w := webhookEvent{
+		Type:      e.Type,
+		Version:   e.Version,
+		CreatedAt: e.CreatedAt,
+		Data:      rawJSON(e.Data),
+		Meta:      rawJSON(e.Meta),
+	}
+
+	w.Aggregate.ID, w.Aggregate.Type = a.ID, a.Type
+	return w
+}
+
+// rawJSON embeds data as it is, when it is JSON, otherwise as string.
+func rawJSON(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+
+	if json.Valid(b) {
+		return b
+	}
+
+	s, _ := json.Marshal(string(b))
+	return s
+}
+
+// SignWebhook computes X-Event-Signature header of webhook request, as
+// "sha256=" followed by hex encoded HMAC-SHA256 of "<timestamp>.<body>".
+func SignWebhook(secret, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp))
+	m.Write([]byte("."))
+	m.Write(body)
+
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// VerifyWebhook checks signature of webhook request, which body was already
+// read, rejecting requests older than maxAge.
+func VerifyWebhook(r *http.Request, body []byte, secret string, maxAge time.Duration) error {
+	timestamp := r.Header.Get("X-Event-Timestamp")
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp")
+	}
+
+	if maxAge > 0 && time.Since(time.Unix(t, 0)) > maxAge {
+		return fmt.Errorf("webhook request expired")
+	}
+
+	if !hmac.Equal([]byte(r.Header.Get("X-Event-Signature")), []byte(SignWebhook(secret, timestamp, body))) {
+		return fmt.Errorf("invalid webhook signature")
+	}
+
+	return nil
+}
+
+type WebhookOption func(*webhooks)
+
+// WebhookClient sends requests, default client times out after 10 seconds.
+func WebhookClient(c *http.Client) WebhookOption {
+	return func(w *webhooks) { w.client = c }
+}
+
+// WebhookRetry sets number of delivery attempts of one event and delay before
+// second attempt, doubled after every next one. Default is 5 attempts, starting
+// from one second.
+func WebhookRetry(attempts int, backoff time.Duration) WebhookOption {
+	return func(w *webhooks) { w.attempts, w.backoff = attempts, backoff }
+}
+
+// WebhookMaxFailures disables endpoint after given number of events in a row
+// it failed to receive, zero never disables it. Default is 10.
+func WebhookMaxFailures(n int) WebhookOption {
+	return func(w *webhooks) { w.maxFailures = n }
+}
+
+// WebhookHistory sets number of delivery log entries kept per endpoint,
+// default is 100.
+func WebhookHistory(n int) WebhookOption {
+	return func(w *webhooks) { w.history = n }
+}
+
+// WebhookBuffer sets number of events queued per endpoint, events published
+// when queue is full are dropped. Default is 1024.
+func WebhookBuffer(n int) WebhookOption {
+	return func(w *webhooks) { w.buffer = n }
+}
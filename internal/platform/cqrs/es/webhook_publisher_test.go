@@ -0,0 +1,164 @@
+package es_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestWebhooks(t *testing.T) {
+	failures := 2
+	received := make(chan string, 16)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := es.VerifyWebhook(r, body, "secret", time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if failures > 0 {
+			failures--
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+
+		received <- r.Header.Get("X-Event-Id")
+	}))
+	defer s.Close()
+
+	w := es.NewWebhookPublisher(es.WebhookRetry(3, time.Millisecond))
+	h := w.Register(s.URL, "secret").AggregateEvents("Book", "Created", "Renamed")
+
+	publish(t, w, "dune", "Book", "Created", "Renamed", "Archived")
+	for _, id := range []string{"dune.Book.Created.1", "dune.Book.Renamed.2"} {
+		select {
+		case r := <-received:
+			if r != id {
+				t.Fatalf("%s expected, got %s", id, r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s expected", id)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := h.Deliveries(); len(d) != 4 || d[0].Status != http.StatusServiceUnavailable || d[2].Attempt != 3 || d[3].Status != http.StatusOK {
+		t.Fatalf("4 delivery attempts expected, got %+v", d)
+	}
+}
+
+func TestWebhooksFullQueue(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer slow.Close()
+
+	received := make(chan string, 16)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Event-Id")
+	}))
+	defer fast.Close()
+
+	w := es.NewWebhookPublisher(es.WebhookBuffer(1))
+	defer w.Close()
+	defer close(release)
+
+	h := w.Register(slow.URL, "secret").AggregateEvents("Book")
+	w.Register(fast.URL, "secret").AggregateEvents("Book")
+
+	for _, id := range []string{"dune", "emma", "ubik"} {
+		publish(t, w, id, "Book", "Created")
+		select {
+		case r := <-received:
+			if r != id+".Book.Created.1" {
+				t.Fatalf("%s expected, got %s", id, r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s expected", id)
+		}
+	}
+
+	var dropped int
+	for _, d := range h.Deliveries() {
+		if d.Error == "queue is full, dropped" {
+			dropped++
+		}
+	}
+
+	if dropped == 0 {
+		t.Fatal("events dropped by slow endpoint expected")
+	}
+}
+
+func TestWebhooksClose(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	w := es.NewWebhookPublisher(es.WebhookRetry(5, time.Hour))
+	h := w.Register(s.URL, "secret").AggregateEvents("Book")
+	publish(t, w, "dune", "Book", "Created")
+
+	for len(h.Deliveries()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	now := time.Now()
+	if err := w.Close(); err != nil || time.Since(now) > time.Second {
+		t.Fatalf("close without waiting for retry expected, got %v after %s", err, time.Since(now))
+	}
+}
+
+func TestWebhooksRegisterAgain(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 16)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Event-Id")
+		if r.Header.Get("X-Event-Id") == "dune.Book.Created.1" {
+			<-release
+		}
+	}))
+	defer s.Close()
+
+	w := es.NewWebhookPublisher()
+	w.Register(s.URL, "secret").AggregateEvents("Book")
+	publish(t, w, "dune", "Book", "Created")
+
+	if r := <-received; r != "dune.Book.Created.1" {
+		t.Fatalf("created event expected, got %s", r)
+	}
+
+	// events of endpoint registered again wait for events queued before.
+	w.Register(s.URL, "secret").AggregateEvents("Book")
+	if err := w.Publish(es.AggregateEvents{Aggregate: es.Aggregate{ID: "dune", Type: "Book"}, Events: []es.Event{{Type: "Renamed", Version: 2, Data: []byte(`{}`)}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-received:
+		t.Fatalf("waiting for previous delivery expected, got %s", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if r := <-received; r != "dune.Book.Renamed.2" {
+		t.Fatalf("renamed event expected, got %s", r)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Register(s.URL, "secret").AggregateEvents("Book")
+	publish(t, w, "dune", "Book", "Created")
+	if _, ok := w.Endpoint(s.URL); ok {
+		t.Fatal("endpoint registered after close is not expected")
+	}
+}
@@ -0,0 +1,89 @@
+package cqrs
+
+import (
+	"errors"
+
+	"github.com/sokool/gokit/log"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+// Executor runs command on aggregate: creates it, loads its events, calls
+// command and stores new events. When events were stored by someone else in
+// meantime, whole sequence is repeated on fresh aggregate.
+type Executor struct {
+	repository *Repository
+	events     Registry
+	aggregate  func(id string) AggregateRoot
+	retries    int
+}
+
+// NewExecutor creates Executor of aggregates made by given function, with
+// events decoded by Registry, they are stored under names given by Registry of
+// Repository. By default conflict is retried 3 times.
+func NewExecutor(r *Repository, events Registry, aggregate func(id string) AggregateRoot) *Executor {
+	return &Executor{
+		repository: r,
+		events:     events,
+		aggregate:  aggregate,
+		retries:    3,
+	}
+}
+
+// Retries sets how many times command is repeated on concurrency conflict.
+func (x *Executor) Retries(n int) *Executor { x.retries = n; return x }
+
+// Execute calls f on loaded aggregate and stores events it raised. Response
+// has new version of aggregate and names of stored events.
+func (x *Executor) Execute(id string, m Meta, f func(AggregateRoot) error) Response {
+	for attempt := 0; ; attempt++ {
+		r := x.execute(id, m, f)
+		if !errors.Is(r.Error, es.ErrConcurrency) || attempt >= x.retries {
+			return r
+		}
+
+		log.Debug("cqrs.executor", "%s.%s concurrency conflict, attempt %d", r.ID, r.Name, attempt+1)
+	}
+}
+
+// Handler creates CommandHandler executing commands with f.
+func (x *Executor) Handler(f func(a AggregateRoot, command interface{}, m Meta) error) CommandHandler {
+	return CommandHandlerFunc(func(id string, c interface{}, m Meta) Response {
+		return x.Execute(id, m, func(a AggregateRoot) error { return f(a, c, m) })
+	})
+}
+
+func (x *Executor) execute(id string, m Meta, f func(AggregateRoot) error) Response {
+	a := x.aggregate(id)
+	if err := x.repository.Load(a, x.events); err != nil {
+		return Response{ID: id, Error: err}
+	}
+
+	id, n, v := a.Details()
+	if err := f(a); err != nil {
+		return Response{ID: id, Name: n, Version: v, Error: err}
+	}
+
+	var ee []string
+	for _, e := range a.Uncommitted(false) {
+		en, err := x.repository.events.Name(e)
+		if err != nil {
+			return Response{ID: id, Name: n, Version: v, Error: err}
+		}
+
+		ee = append(ee, en)
+	}
+
+	if len(ee) == 0 {
+		return Response{ID: id, Name: n, Version: v}
+	}
+
+	if err := x.repository.Store(a, m); err != nil {
+		if !errors.Is(err, ErrNotPublished) {
+			return Response{ID: id, Name: n, Version: v, Error: err}
+		}
+
+		log.Error("cqrs.executor", err)
+	}
+
+	return Response{ID: id, Name: n, Version: v + uint(len(ee)), Events: ee}
+}
@@ -0,0 +1,422 @@
+package cqrs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sokool/gokit/log"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+const gtag = "cqrs.gateway"
+
+// StreamFilter chooses events streamed to client of Gateway, empty ID or
+// Events means all of them.
+type StreamFilter struct {
+	Aggregate string
+	ID        string
+	Events    []string
+}
+
+func (f StreamFilter) match(a es.Aggregate, e es.Event) bool {
+	if f.Aggregate != a.Type || (f.ID != "" && f.ID != a.ID) {
+		return false
+	}
+
+	if len(f.Events) == 0 {
+		return true
+	}
+
+	for i := range f.Events {
+		if f.Events[i] == e.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Gateway is an http.Handler streaming live events to clients over
+// Server-Sent Events or WebSocket. Client chooses events with query
+// parameters:
+//
+//	GET /?aggregate=Order&id=1&event=Created&event=Paid
+//
+// When Feed is set, client which sends Last-Event-ID header (or last_event_id
+// parameter), receives events stored after it, before live ones. Event ID is
+// its Position, so resuming works only with storage tracking it (Postgres
+// store, or MySQL feed), without Feed such requests are rejected. Live events
+// have Position only when Subscriber reads them from Feed (NewPostgresSubscriber
+// or NewPollingSubscriber), events of brokers and memory pubsub have none, so
+// they are sent without ID, client can not resume after them, and live events
+// which were already replayed are sent again.
+//
+// WebSocket connections are accepted from the same origin, or from origins
+// allowed with Origins.
+type Gateway struct {
+	feed      es.Feed
+	authorize func(Meta, StreamFilter) error
+	buffer    int
+	origins   map[string]bool
+	upgrader  websocket.Upgrader
+
+	mu      sync.RWMutex
+	clients map[*streamClient]bool
+}
+
+type streamClient struct {
+	filter StreamFilter
+	// resume tells that client sent last event ID, zero one replays all
+	// stored events.
+	resume bool
+	events chan streamEvent
+	once   sync.Once
+	done   chan struct{}
+}
+
+type streamEvent struct {
+	Position  uint64          `json:"id,omitempty"`
+	Aggregate string          `json:"aggregate"`
+	ID        string          `json:"aggregate_id"`
+	Type      string          `json:"type"`
+	Version   uint            `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// NewGateway subscribes to events of given aggregates, and streams them to
+// connected clients. Subscription has no name, so every instance of Gateway
+// receives all events, for its own clients.
+func NewGateway(s es.Subscriber, aggregates ...string) (*Gateway, error) {
+	g := &Gateway{
+		buffer:  64,
+		origins: make(map[string]bool),
+		clients: make(map[*streamClient]bool),
+	}
+
+	g.upgrader.CheckOrigin = g.checkOrigin
+
+	z := es.NewSubscription(g.broadcast)
+	for _, a := range aggregates {
+		z.AggregateEvents(a)
+	}
+
+	if err := s.Subscribe(*z); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Feed lets clients resume stream from position of last received event.
+func (g *Gateway) Feed(f es.Feed) *Gateway { g.feed = f; return g }
+
+// Authorize decides if client, described by Meta from its request headers,
+// may stream events chosen by StreamFilter.
+func (g *Gateway) Authorize(f func(Meta, StreamFilter) error) *Gateway { g.authorize = f; return g }
+
+// Buffer sets number of live events waiting for slow client, before it is
+// disconnected. Default is 64.
+func (g *Gateway) Buffer(n int) *Gateway { g.buffer = n; return g }
+
+// Origins allows WebSocket connections from pages of given origins, like
+// "https://example.com", besides the same origin.
+func (g *Gateway) Origins(oo ...string) *Gateway {
+	for _, o := range oo {
+		g.origins[strings.ToLower(o)] = true
+	}
+
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := StreamFilter{
+		Aggregate: q.Get("aggregate"),
+		ID:        q.Get("id"),
+		Events:    q["event"],
+	}
+
+	if f.Aggregate == "" {
+		http.Error(w, "aggregate parameter required", http.StatusBadRequest)
+		return
+	}
+
+	if g.authorize != nil {
+		if err := g.authorize(MetaFromHTTP(r), f); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	var position uint64
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = q.Get("last_event_id")
+	}
+
+	if last != "" {
+		if g.feed == nil {
+			http.Error(w, "stream can not be resumed", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if position, err = strconv.ParseUint(last, 10, 64); err != nil {
+			http.Error(w, "invalid last event id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	c := &streamClient{
+		filter: f,
+		resume: last != "",
+		events: make(chan streamEvent, g.buffer),
+		done:   make(chan struct{}),
+	}
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.clients, c)
+		g.mu.Unlock()
+		c.close()
+	}()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		g.websocket(w, r, c, position)
+		return
+	}
+
+	g.sse(w, r, c, position)
+}
+
+func (g *Gateway) sse(w http.ResponseWriter, r *http.Request, c *streamClient, position uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// headers are sent with first event or ping, after client receives live
+	// events.
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	write := func(e streamEvent) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		if e.Position > 0 {
+			fmt.Fprintf(w, "id: %d\n", e.Position)
+		}
+
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}
+
+	ping := func() error {
+		if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}
+
+	g.stream(r, c, position, write, ping)
+}
+
+func (g *Gateway) websocket(w http.ResponseWriter, r *http.Request, c *streamClient, position uint64) {
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error(gtag, err)
+		return
+	}
+
+	defer conn.Close()
+
+	// client does not send anything, reading detects closed connection.
+	go func() {
+		defer c.close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(e streamEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(e)
+	}
+
+	ping := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+	}
+
+	g.stream(r, c, position, write, ping)
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+}
+
+// stream replays stored events after position, then writes live ones until
+// client disconnects. Client receives live events after replay, which is
+// written at pace of client, then events stored meanwhile are replayed again,
+// and live ones which were already replayed are skipped. Client is pinged,
+// once it receives live events.
+func (g *Gateway) stream(r *http.Request, c *streamClient, position uint64, write func(streamEvent) error, ping func() error) {
+	if c.resume {
+		var err error
+		if position, err = g.replay(c, position, write); err != nil {
+			log.Error(gtag, err)
+			return
+		}
+	}
+
+	g.mu.Lock()
+	g.clients[c] = true
+	g.mu.Unlock()
+
+	if err := ping(); err != nil {
+		return
+	}
+
+	if c.resume {
+		var err error
+		if position, err = g.replay(c, position, write); err != nil {
+			log.Error(gtag, err)
+			return
+		}
+	}
+
+	t := time.NewTicker(15 * time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-c.done:
+			return
+
+		case e := <-c.events:
+			if e.Position > 0 && e.Position <= position {
+				continue
+			}
+
+			if err := write(e); err != nil {
+				return
+			}
+
+		case <-t.C:
+			if err := ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (g *Gateway) replay(c *streamClient, position uint64, write func(streamEvent) error) (uint64, error) {
+	const batch = 500
+	for {
+		aa, err := g.feed.After(position, batch)
+		if err != nil {
+			return position, err
+		}
+
+		var n int
+		for _, a := range aa {
+			for _, e := range a.Events {
+				n++
+				position = e.Position
+				if !c.filter.match(a.Aggregate, e) {
+					continue
+				}
+
+				if err := write(newStreamEvent(a.Aggregate, e)); err != nil {
+					return position, err
+				}
+			}
+		}
+
+		if n < batch {
+			return position, nil
+		}
+	}
+}
+
+// broadcast live event to clients, client which does not keep up is
+// disconnected, it can resume stream with Last-Event-ID.
+func (g *Gateway) broadcast(a es.Aggregate, e es.Event) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var s *streamEvent
+	for c := range g.clients {
+		if !c.filter.match(a, e) {
+			continue
+		}
+
+		if s == nil {
+			v := newStreamEvent(a, e)
+			s = &v
+		}
+
+		select {
+		case c.events <- *s:
+		default:
+			log.Debug(gtag, "%s.%s client is too slow, disconnecting", a.Type, a.ID)
+			c.close()
+		}
+	}
+
+	return nil
+}
+
+// checkOrigin accepts WebSocket requests without Origin header, from the same
+// host, or from allowed origins.
+func (g *Gateway) checkOrigin(r *http.Request) bool {
+	o := r.Header.Get("Origin")
+	if o == "" || g.origins[strings.ToLower(o)] {
+		return true
+	}
+
+	u, err := url.Parse(o)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (c *streamClient) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+func newStreamEvent(a es.Aggregate, e es.Event) streamEvent {
+	s := streamEvent{
+		Position:  e.Position,
+		Aggregate: a.Type,
+		ID:        a.ID,
+		Type:      e.Type,
+		Version:   e.Version,
+		CreatedAt: e.CreatedAt,
+		Data:      e.Data,
+	}
+
+	if !json.Valid(s.Data) {
+		s.Data, _ = json.Marshal(string(e.Data))
+	}
+
+	return s
+}
@@ -0,0 +1,276 @@
+package cqrs_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestGatewaySSE(t *testing.T) {
+	ps := es.NewMemPubSub(es.MemSynchronous())
+	f := &gatewayFeed{}
+	g, err := cqrs.NewGateway(ps, "Book")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(g.Feed(f))
+	defer s.Close()
+
+	live := func(position uint64, id, event string) {
+		t.Helper()
+		a := es.AggregateEvents{
+			Aggregate: es.Aggregate{ID: id, Type: "Book"},
+			Events:    []es.Event{{Type: event, Version: 1, Data: []byte(`{}`), Position: position}},
+		}
+
+		f.add(a)
+		if err := ps.Publish(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	live(1, "dune", "Created")
+	live(2, "emma", "Created")
+	live(3, "dune", "Renamed")
+
+	cases := []struct {
+		desc    string
+		query   string
+		last    string
+		status  int
+		expects []string
+	}{
+		{"live events", "aggregate=Book&id=dune", "", http.StatusOK, []string{"4 Archived", "3 Renamed", "5 Archived"}},
+		{"resumed stream", "aggregate=Book&id=dune", "1", http.StatusOK, []string{"3 Renamed", "4 Archived", "5 Archived"}},
+		{"resumed from start", "aggregate=Book&event=Created&last_event_id=0", "", http.StatusOK, []string{"1 Created", "2 Created"}},
+		{"without aggregate", "id=dune", "", http.StatusBadRequest, nil},
+		{"invalid last event id", "aggregate=Book", "x", http.StatusBadRequest, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, s.URL+"?"+c.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.last != "" {
+				r.Header.Set("Last-Event-ID", c.last)
+			}
+
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+			if res.StatusCode != c.status {
+				t.Fatalf("%d status expected, got %d", c.status, res.StatusCode)
+			}
+
+			if c.status != http.StatusOK {
+				return
+			}
+
+			// client receives live events, once headers are sent, resumed
+			// client skips live events which were replayed.
+			n := f.len()
+			defer f.truncate(n)
+			live(4, "dune", "Archived")
+			live(3, "dune", "Renamed")
+			live(5, "dune", "Archived")
+
+			b := bufio.NewReader(res.Body)
+			for _, e := range c.expects {
+				if got := sse(t, b); got != e {
+					t.Fatalf("%s event expected, got %s", e, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGatewayWebSocket(t *testing.T) {
+	ps := es.NewMemPubSub(es.MemSynchronous())
+	g, err := cqrs.NewGateway(ps, "Book")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(g.Origins("https://shelf.example"))
+	defer s.Close()
+
+	cases := []struct {
+		desc   string
+		origin string
+		ok     bool
+	}{
+		{"without origin", "", true},
+		{"same origin", s.URL, true},
+		{"allowed origin", "https://SHELF.example", true},
+		{"other origin", "https://evil.example", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			h := http.Header{}
+			if c.origin != "" {
+				h.Set("Origin", c.origin)
+			}
+
+			conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"?aggregate=Book&event=Renamed", h)
+			if !c.ok {
+				if err == nil || res.StatusCode != http.StatusForbidden {
+					t.Fatalf("rejected connection expected, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer conn.Close()
+
+			// client is pinged, once it receives live events.
+			ready := make(chan struct{}, 1)
+			conn.SetPingHandler(func(string) error { ready <- struct{}{}; return nil })
+
+			events := make(chan map[string]interface{}, 16)
+			go func() {
+				for {
+					var e map[string]interface{}
+					if err := conn.ReadJSON(&e); err != nil {
+						close(events)
+						return
+					}
+					events <- e
+				}
+			}()
+
+			select {
+			case <-ready:
+			case <-time.After(5 * time.Second):
+				t.Fatal("ping expected")
+			}
+
+			publish := func(event string) {
+				t.Helper()
+				a := es.AggregateEvents{
+					Aggregate: es.Aggregate{ID: "dune", Type: "Book"},
+					Events:    []es.Event{{Type: event, Version: 1, Data: []byte(`{"Name":"Dune"}`)}},
+				}
+
+				if err := ps.Publish(a); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			publish("Created")
+			publish("Renamed")
+
+			select {
+			case e := <-events:
+				if e["type"] != "Renamed" || e["aggregate_id"] != "dune" || e["data"].(map[string]interface{})["Name"] != "Dune" {
+					t.Fatalf("renamed event expected, got %v", e)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("event expected")
+			}
+		})
+	}
+}
+
+// sse reads next event from stream, as "<id> <type>", skipping pings.
+func sse(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+
+	done := make(chan string, 1)
+	go func() {
+		var id, typ string
+		for {
+			l, err := r.ReadString('\n')
+			if err != nil {
+				done <- err.Error()
+				return
+			}
+
+			switch l = strings.TrimSuffix(l, "\n"); {
+			case strings.HasPrefix(l, "id: "):
+				id = strings.TrimPrefix(l, "id: ")
+			case strings.HasPrefix(l, "event: "):
+				typ = strings.TrimPrefix(l, "event: ")
+			case l == "" && typ != "":
+				done <- id + " " + typ
+				return
+			}
+		}
+	}()
+
+	select {
+	case e := <-done:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("event expected")
+		return ""
+	}
+}
+
+// gatewayFeed of events in order of their positions.
+type gatewayFeed struct {
+	mu     sync.Mutex
+	events []es.AggregateEvents
+}
+
+func (f *gatewayFeed) add(a es.AggregateEvents) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// event published again is stored once.
+	for _, o := range f.events {
+		if o.Events[0].Position == a.Events[0].Position {
+			return
+		}
+	}
+
+	f.events = append(f.events, a)
+}
+
+func (f *gatewayFeed) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.events)
+}
+
+func (f *gatewayFeed) truncate(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = f.events[:n]
+}
+
+func (f *gatewayFeed) After(position uint64, limit int) ([]es.AggregateEvents, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []es.AggregateEvents
+	for _, a := range f.events {
+		if a.Events[0].Position > position && len(out) < limit {
+			out = append(out, a)
+		}
+	}
+
+	return out, nil
+}
+
+func (f *gatewayFeed) Head() (uint64, error) { return uint64(f.len()), nil }
@@ -0,0 +1,387 @@
+package cqrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokool/gokit/log"
+)
+
+// ErrNotFound is returned by QueryHandler, when read model has no result.
+var ErrNotFound = errors.New("not found")
+
+type QueryHandler interface {
+	Ask(query interface{}, m Meta) (interface{}, error)
+}
+
+type QueryHandlerFunc func(interface{}, Meta) (interface{}, error)
+
+func (f QueryHandlerFunc) Ask(query interface{}, m Meta) (interface{}, error) {
+	return f(query, m)
+}
+
+// QueryMiddleware wraps QueryHandler with behaviour common to all queries.
+type QueryMiddleware func(QueryHandler) QueryHandler
+
+// QueryBus dispatches queries to handlers registered for their types, through
+// middleware chain, the same way CommandBus dispatches commands.
+type QueryBus struct {
+	mu         sync.RWMutex
+	queries    Registry
+	handlers   map[string]QueryHandler
+	middleware []QueryMiddleware
+}
+
+func NewQueryBus() *QueryBus {
+	return &QueryBus{
+		queries:  Registry{},
+		handlers: make(map[string]QueryHandler),
+	}
+}
+
+// Register handler of query type, one type has one handler.
+func (b *QueryBus) Register(query interface{}, h QueryHandler) error {
+	n := name(query)
+	if n == "" {
+		return fmt.Errorf("query name not resolved")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[n]; ok {
+		return fmt.Errorf("query %q already registered", n)
+	}
+
+	if t := reflect.TypeOf(query); t.Kind() == reflect.Ptr {
+		query = reflect.New(t.Elem()).Elem().Interface()
+	}
+
+	if err := b.queries.Register(query, n); err != nil {
+		return err
+	}
+
+	b.handlers[n] = h
+	return nil
+}
+
+// Use appends middleware, first one is outermost.
+func (b *QueryBus) Use(mm ...QueryMiddleware) *QueryBus {
+	b.mu.Lock()
+	b.middleware = append(b.middleware, mm...)
+	b.mu.Unlock()
+
+	return b
+}
+
+// Query creates pointer to zero value of registered query, ready to be
+// decoded.
+func (b *QueryBus) Query(name string) (interface{}, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	v, err := b.queries.Type(name)
+	if err != nil {
+		return nil, fmt.Errorf("query %q not registered", name)
+	}
+
+	return v.Interface(), nil
+}
+
+func (b *QueryBus) Ask(query interface{}, m Meta) (interface{}, error) {
+	n := name(query)
+
+	b.mu.RLock()
+	h, ok := b.handlers[n]
+	mm := b.middleware
+	b.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("query %q not registered", n)
+	}
+
+	for i := len(mm) - 1; i >= 0; i-- {
+		h = mm[i](h)
+	}
+
+	return h.Ask(query, m)
+}
+
+// RegisterQuery registers in QueryBus handler of query type Q, returning R.
+func RegisterQuery[Q, R any](b *QueryBus, f func(query Q, m Meta) (R, error)) error {
+	var q Q
+	return b.Register(q, QueryHandlerFunc(func(v interface{}, m Meta) (interface{}, error) {
+		t, ok := typed[Q](v)
+		if !ok {
+			return nil, fmt.Errorf("%T query given, %T expected", v, q)
+		}
+
+		return f(t, m)
+	}))
+}
+
+// Ask sends query through QueryBus, expecting result of type R.
+func Ask[R any](b *QueryBus, query interface{}, m Meta) (R, error) {
+	v, err := b.Ask(query, m)
+	if err != nil {
+		var r R
+		return r, err
+	}
+
+	r, ok := typed[R](v)
+	if !ok {
+		return r, fmt.Errorf("%s query returned %T, %T expected", name(query), v, r)
+	}
+
+	return r, nil
+}
+
+// QueryValidation rejects query implementing Validator when it is not valid,
+// error wraps ErrValidation. Query sent as value is validated also when
+// Validate has pointer receiver.
+func QueryValidation() QueryMiddleware {
+	return func(next QueryHandler) QueryHandler {
+		return QueryHandlerFunc(func(q interface{}, m Meta) (interface{}, error) {
+			if v, ok := validator(q); ok {
+				if err := v.Validate(); err != nil {
+					return nil, fmt.Errorf("%w: %s", ErrValidation, err)
+				}
+			}
+
+			return next.Ask(q, m)
+		})
+	}
+}
+
+// QueryAuthorization rejects query when f returns error, error wraps
+// ErrUnauthorized.
+func QueryAuthorization(f func(query interface{}, m Meta) error) QueryMiddleware {
+	return func(next QueryHandler) QueryHandler {
+		return QueryHandlerFunc(func(q interface{}, m Meta) (interface{}, error) {
+			if err := f(q, m); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrUnauthorized, err)
+			}
+
+			return next.Ask(q, m)
+		})
+	}
+}
+
+// QueryCache keeps results of up to size queries for ttl, queries are equal
+// when their names and JSON are equal, Meta is not compared, so
+// QueryAuthorization should be used before it. Cached result is shared by
+// callers, so it must not be modified. When cache is full, expired results
+// are removed, or the one expiring first.
+func QueryCache(ttl time.Duration, size int) QueryMiddleware {
+	type entry struct {
+		result  interface{}
+		expires time.Time
+	}
+
+	var mu sync.Mutex
+	cache := make(map[string]entry)
+
+	return func(next QueryHandler) QueryHandler {
+		return QueryHandlerFunc(func(q interface{}, m Meta) (interface{}, error) {
+			b, err := json.Marshal(q)
+			if err != nil {
+				return next.Ask(q, m)
+			}
+
+			k := name(q) + string(b)
+			now := time.Now()
+
+			mu.Lock()
+			e, ok := cache[k]
+			mu.Unlock()
+
+			if ok && now.Before(e.expires) {
+				return e.result, nil
+			}
+
+			r, err := next.Ask(q, m)
+			if err != nil {
+				return nil, err
+			}
+
+			mu.Lock()
+			if _, ok := cache[k]; !ok && len(cache) >= size {
+				var first string
+				for c, e := range cache {
+					if !now.Before(e.expires) {
+						delete(cache, c)
+					} else if first == "" || e.expires.Before(cache[first].expires) {
+						first = c
+					}
+				}
+
+				if len(cache) >= size {
+					delete(cache, first)
+				}
+			}
+
+			if size > 0 {
+				cache[k] = entry{result: r, expires: now.Add(ttl)}
+			}
+			mu.Unlock()
+
+			return r, nil
+		})
+	}
+}
+
+// QueryEndpoint is an http.Handler asking QueryBus with queries sent as
+//
+//	GET /{query}?field=value
+//	POST /{query}
+//
+// where POST has query in JSON body. Result is written as JSON with status:
+//
+//	200 query answered
+//	400 malformed query
+//	403 ErrUnauthorized
+//	404 query not registered or ErrNotFound
+//	422 ErrValidation
+//	500 any other error
+type QueryEndpoint struct {
+	bus     *QueryBus
+	headers []string
+}
+
+func NewQueryEndpoint(b *QueryBus) *QueryEndpoint {
+	return &QueryEndpoint{bus: b}
+}
+
+// Headers sets request headers passed as Meta, by default all are passed.
+func (e *QueryEndpoint) Headers(names ...string) *QueryEndpoint {
+	e.headers = names
+	return e
+}
+
+func (e *QueryEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := strings.Trim(r.URL.Path, "/")
+	if n == "" || strings.Contains(n, "/") {
+		e.write(w, http.StatusNotFound, nil, fmt.Errorf("path must be /{query}"))
+		return
+	}
+
+	q, err := e.bus.Query(n)
+	if err != nil {
+		e.write(w, http.StatusNotFound, nil, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		err = decodeValues(r, q)
+	case http.MethodPost:
+		if err = json.NewDecoder(r.Body).Decode(q); err == io.EOF {
+			err = nil
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		e.write(w, http.StatusMethodNotAllowed, nil, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	if err != nil {
+		e.write(w, http.StatusBadRequest, nil, fmt.Errorf("%s query decoding: %s", n, err))
+		return
+	}
+
+	m := MetaFromHTTP(r)
+	if len(e.headers) > 0 {
+		o := make(Meta)
+		for _, h := range e.headers {
+			h = http.CanonicalHeaderKey(h)
+			if v, ok := m[h]; ok {
+				o[h] = v
+			}
+		}
+		m = o
+	}
+
+	res, err := e.bus.Ask(reflect.ValueOf(q).Elem().Interface(), m)
+	switch {
+	case err == nil:
+		e.write(w, http.StatusOK, res, nil)
+	case errors.Is(err, ErrNotFound):
+		e.write(w, http.StatusNotFound, nil, err)
+	case errors.Is(err, ErrValidation):
+		e.write(w, http.StatusUnprocessableEntity, nil, err)
+	case errors.Is(err, ErrUnauthorized):
+		e.write(w, http.StatusForbidden, nil, err)
+	default:
+		e.write(w, http.StatusInternalServerError, nil, err)
+	}
+}
+
+func (e *QueryEndpoint) write(w http.ResponseWriter, status int, v interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err != nil {
+		v = map[string]string{"errors": err.Error()}
+	}
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("cqrs.query", err)
+	}
+}
+
+// decodeValues of URL query into struct v through JSON, values of string
+// fields are decoded as strings, others as JSON, repeated parameter as array.
+func decodeValues(r *http.Request, v interface{}) error {
+	t := reflect.TypeOf(v).Elem()
+	quoted := make(map[string]bool)
+	for i := 0; t.Kind() == reflect.Struct && i < t.NumField(); i++ {
+		f := t.Field(i)
+		k := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+			k = tag
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+
+		quoted[strings.ToLower(k)] = ft.Kind() == reflect.String
+	}
+
+	raw := func(k, s string) json.RawMessage {
+		if quoted[strings.ToLower(k)] || !json.Valid([]byte(s)) {
+			b, _ := json.Marshal(s)
+			return b
+		}
+
+		return json.RawMessage(s)
+	}
+
+	o := make(map[string]interface{})
+	for k, vv := range r.URL.Query() {
+		if len(vv) == 1 {
+			o[k] = raw(k, vv[0])
+			continue
+		}
+
+		var a []json.RawMessage
+		for _, s := range vv {
+			a = append(a, raw(k, s))
+		}
+		o[k] = a
+	}
+
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,178 @@
+package cqrs_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+)
+
+type FindBook struct {
+	ID   string
+	Tags []string
+}
+
+func (f *FindBook) Validate() error {
+	if f.ID == "" {
+		return fmt.Errorf("id required")
+	}
+
+	return nil
+}
+
+type bookView struct {
+	ID    string
+	Title string
+}
+
+func TestQueryBus(t *testing.T) {
+	var order []string
+	trace := func(n string) cqrs.QueryMiddleware {
+		return func(next cqrs.QueryHandler) cqrs.QueryHandler {
+			return cqrs.QueryHandlerFunc(func(q interface{}, m cqrs.Meta) (interface{}, error) {
+				order = append(order, n)
+				return next.Ask(q, m)
+			})
+		}
+	}
+
+	b := cqrs.NewQueryBus().Use(trace("first"), cqrs.QueryValidation(), trace("second"))
+	err := cqrs.RegisterQuery(b, func(q FindBook, m cqrs.Meta) (bookView, error) {
+		order = append(order, "handler")
+		return bookView{ID: q.ID, Title: "Dune"}, nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cqrs.RegisterQuery(b, func(q *FindBook, m cqrs.Meta) (bookView, error) { return bookView{}, nil }); err == nil {
+		t.Fatal("query registered twice rejected expected")
+	}
+
+	v, err := cqrs.Ask[bookView](b, FindBook{ID: "dune"}, nil)
+	if err != nil || v.Title != "Dune" {
+		t.Fatalf("Dune expected, got %+v %v", v, err)
+	}
+
+	if strings.Join(order, ",") != "first,second,handler" {
+		t.Fatalf("middleware in order of Use expected, got %v", order)
+	}
+
+	// query sent as value is validated with pointer receiver.
+	for _, q := range []interface{}{FindBook{}, &FindBook{}} {
+		if _, err := b.Ask(q, nil); !errors.Is(err, cqrs.ErrValidation) {
+			t.Fatalf("ErrValidation expected for %T, got %v", q, err)
+		}
+	}
+
+	if _, err := cqrs.Ask[string](b, FindBook{ID: "dune"}, nil); err == nil {
+		t.Fatal("result of other type rejected expected")
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	var asked int
+	b := cqrs.NewQueryBus().Use(cqrs.QueryCache(time.Minute, 2))
+	err := cqrs.RegisterQuery(b, func(q FindBook, m cqrs.Meta) (string, error) {
+		asked++
+		return q.ID, nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"dune", "dune", "emma", "dune", "ubik", "emma", "dune"} {
+		if r, err := cqrs.Ask[string](b, FindBook{ID: id}, nil); err != nil || r != id {
+			t.Fatalf("%s expected, got %s %v", id, r, err)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// ubik evicts dune, which expires first, then dune evicts emma.
+	if asked != 4 {
+		t.Fatalf("4 queries asked expected, got %d", asked)
+	}
+}
+
+func TestQueryEndpoint(t *testing.T) {
+	b := cqrs.NewQueryBus().Use(
+		cqrs.QueryAuthorization(func(q interface{}, m cqrs.Meta) error {
+			if m["Authorization"] == "" {
+				return fmt.Errorf("token required")
+			}
+			return nil
+		}),
+		cqrs.QueryValidation(),
+	)
+
+	err := cqrs.RegisterQuery(b, func(q FindBook, m cqrs.Meta) (bookView, error) {
+		switch q.ID {
+		case "dune":
+			return bookView{ID: q.ID, Title: "Dune " + strings.Join(q.Tags, ",")}, nil
+		case "lost":
+			return bookView{}, cqrs.ErrNotFound
+		}
+
+		return bookView{}, fmt.Errorf("database is down")
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(cqrs.NewQueryEndpoint(b))
+	defer s.Close()
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		response           string
+	}{
+		{"GET", "/FindBook?ID=dune&Tags=sf&Tags=classic", "", http.StatusOK, `{"ID":"dune","Title":"Dune sf,classic"}`},
+		{"POST", "/FindBook", `{"ID":"dune"}`, http.StatusOK, `{"ID":"dune","Title":"Dune "}`},
+		{"POST", "/FindBook", `{"ID":`, http.StatusBadRequest, ""},
+		{"GET", "/FindBook?ID=lost", "", http.StatusNotFound, ""},
+		{"GET", "/FindAuthor", "", http.StatusNotFound, ""},
+		{"GET", "/FindBook", "", http.StatusUnprocessableEntity, ""},
+		{"GET", "/FindBook?ID=emma", "", http.StatusInternalServerError, ""},
+		{"DELETE", "/FindBook", "", http.StatusMethodNotAllowed, ""},
+		{"GET", "/FindBook?ID=dune", "", http.StatusForbidden, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.method+c.path, func(t *testing.T) {
+			r, err := http.NewRequest(c.method, s.URL+c.path, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.status != http.StatusForbidden {
+				r.Header.Set("Authorization", "token")
+			}
+
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != c.status || (c.response != "" && strings.TrimSpace(string(body)) != c.response) {
+				t.Fatalf("%d %s expected, got %d %s", c.status, c.response, res.StatusCode, body)
+			}
+		})
+	}
+}
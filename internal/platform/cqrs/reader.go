@@ -14,6 +14,8 @@ type EventReader struct {
 	store      es.Storage
 	events     Registry
 	aggregate  es.Aggregate
+	retry      RetryPolicy
+	done       <-chan struct{}
 }
 
 func NewEventReader(m Serializer, s es.Storage, r Registry, name, id string) *EventReader {
@@ -28,11 +30,22 @@ func NewEventReader(m Serializer, s es.Storage, r Registry, name, id string) *Ev
 	}
 }
 
+// Retries repeats handling of events which handler failed to handle,
+// according to given policy.
+func (r *EventReader) Retries(p RetryPolicy) *EventReader { r.retry = p; return r }
+
+// Done stops retries of Read, when given channel is closed.
+func (r *EventReader) Done(c <-chan struct{}) *EventReader { r.done = c; return r }
+
 func (r *EventReader) Read(h EventHandler) error {
 	defer func(t time.Time) {
 		log.Debug("read", "time %s", time.Since(t))
 	}(time.Now())
 
+	if r.retry != nil {
+		h = Retry(h, r.retry, r.done)
+	}
+
 	if r.aggregate.ID != "" {
 		events, err := r.store.FromVersion(r.aggregate, 0)
 		if err != nil {
@@ -98,11 +111,13 @@ func (r *EventReader) read(a es.AggregateEvents, h EventHandler) error {
 			}
 		}
 
+		// event stored under alias is handled under registered name.
+		n, _ := r.events.Resolve(a.Events[i].Type)
 		if err := h.Handle(Event{
 			Aggregate: a.Aggregate,
 			Data:      value.Elem().Interface(),
 			Meta:      m,
-			Type:      a.Events[i].Type,
+			Type:      n,
 			Version:   a.Events[i].Version,
 			CreatedAt: a.Events[i].CreatedAt}); err != nil {
 
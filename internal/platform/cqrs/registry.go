@@ -2,11 +2,19 @@ package cqrs
 
 import (
 	"fmt"
+	"path"
 	"reflect"
+	"sort"
 )
 
+// Registry maps names to types of events. Name registered for one type can not
+// be registered for another, and type has one registered name, aliases map old
+// names of renamed events to current ones, so stored events are still decoded.
 type Registry map[string]interface{}
 
+// alias is value of Registry, pointing to registered name.
+type alias string
+
 func (Registry) New(events ...interface{}) Registry {
 	r := Registry{}
 	for _, e := range events {
@@ -18,34 +26,110 @@ func (Registry) New(events ...interface{}) Registry {
 	return r
 }
 
+// Register type of v under given name, or name resolved from v when empty.
+// Registering the same type again under the same name is not an error, other
+// name of registered type has to be its Alias.
 func (r Registry) Register(v interface{}, n string) error {
 	if n == "" {
 		n = name(v)
 	}
 
-	if _, ok := r[n]; ok {
+	if n == "" {
+		return fmt.Errorf("name of %T not resolved", v)
+	}
+
+	o, ok := r[n]
+	if !ok {
+		if p, err := r.Name(v); len(r) > 0 && err == nil {
+			return fmt.Errorf("event %s already registered as %q, can not register it as %q", elem(v), p, n)
+		}
+
+		r[n] = v
 		return nil
 	}
 
-	r[n] = v
+	if a, ok := o.(alias); ok {
+		return fmt.Errorf("event %q is already an alias of %q", n, string(a))
+	}
+
+	if elem(o) != elem(v) {
+		return fmt.Errorf("event %q already registered as %s, can not register %s", n, elem(o), elem(v))
+	}
+
 	return nil
 }
 
+// Alias makes old name resolve to registered name.
+func (r Registry) Alias(old, name string) error {
+	if _, ok := r[name]; !ok {
+		return fmt.Errorf("event %q not registered, can not be aliased by %q", name, old)
+	}
+
+	if o, ok := r[old]; ok {
+		if a, ok := o.(alias); ok && string(a) == name {
+			return nil
+		}
+
+		return fmt.Errorf("event %q already registered", old)
+	}
+
+	if a, ok := r[name].(alias); ok {
+		name = string(a)
+	}
+
+	r[old] = alias(name)
+	return nil
+}
+
+// Resolve returns registered name of given name or alias.
+func (r Registry) Resolve(name string) (string, error) {
+	o, ok := r[name]
+	if !ok {
+		return "", fmt.Errorf("event %q not registered", name)
+	}
+
+	if a, ok := o.(alias); ok {
+		return string(a), nil
+	}
+
+	return name, nil
+}
+
+// Names returns registered names with aliases.
 func (r Registry) Names() []string {
 	var o []string
 	for n := range r {
 		o = append(o, n)
 	}
+
+	sort.Strings(o)
 	return o
 }
 
 func (r Registry) Type(name string) (reflect.Value, error) {
-	o, ok := r[name]
-	if !ok {
-		return reflect.Value{}, fmt.Errorf("not registered")
+	n, err := r.Resolve(name)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	return reflect.New(reflect.TypeOf(r[n])), nil
+}
+
+// Name returns name under which type of v is registered, empty Registry
+// resolves it from v, like Register.
+func (r Registry) Name(v interface{}) (string, error) {
+	if len(r) == 0 {
+		return name(v), nil
+	}
+
+	t := elem(v)
+	for n, o := range r {
+		if _, ok := o.(alias); !ok && elem(o) == t {
+			return n, nil
+		}
 	}
 
-	return reflect.New(reflect.TypeOf(o)), nil
+	return "", fmt.Errorf("event %s not registered", t)
 }
 
 func (r Registry) Is(name string) bool {
@@ -53,11 +137,43 @@ func (r Registry) Is(name string) bool {
 	return ok
 }
 
+// List returns registered values, without aliases.
 func (r Registry) List() []interface{} {
 	var out []interface{}
-	for _, e := range r {
-		out = append(out, e)
+	for _, n := range r.Names() {
+		if _, ok := r[n].(alias); !ok {
+			out = append(out, r[n])
+		}
 	}
 
 	return out
 }
+
+// QualifiedName of v is its name prefixed by name of its package, like
+// orders.Created, it might be used to register types of the same name from
+// different packages.
+func QualifiedName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return ""
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.PkgPath() == "" {
+		return name(v)
+	}
+
+	return path.Base(t.PkgPath()) + "." + name(v)
+}
+
+func elem(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+
+	return t
+}
@@ -0,0 +1,132 @@
+package cqrs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+)
+
+type Created struct{ Name string }
+
+type Renamed struct{ Name string }
+
+type created struct{ ID int }
+
+func (created) Type() string { return "Created" }
+
+func TestRegistry(t *testing.T) {
+	cases := []struct {
+		desc  string
+		do    func(cqrs.Registry) error
+		error string
+	}{
+		{
+			desc: "same type registered twice",
+			do: func(r cqrs.Registry) error {
+				if err := r.Register(Created{}, ""); err != nil {
+					return err
+				}
+				return r.Register(&Created{}, "")
+			},
+		},
+		{
+			desc: "other type under registered name",
+			do: func(r cqrs.Registry) error {
+				if err := r.Register(Created{}, ""); err != nil {
+					return err
+				}
+				return r.Register(created{}, "")
+			},
+			error: `event "Created" already registered`,
+		},
+		{
+			desc: "registered type under other name",
+			do: func(r cqrs.Registry) error {
+				if err := r.Register(Created{}, ""); err != nil {
+					return err
+				}
+				return r.Register(&Created{}, cqrs.QualifiedName(Created{}))
+			},
+			error: `already registered as "Created"`,
+		},
+		{
+			desc: "alias of not registered name",
+			do: func(r cqrs.Registry) error {
+				return r.Alias("Added", "Created")
+			},
+			error: `event "Created" not registered`,
+		},
+		{
+			desc: "alias of registered name",
+			do: func(r cqrs.Registry) error {
+				if err := r.Register(Created{}, ""); err != nil {
+					return err
+				}
+				return r.Alias("Added", "Created")
+			},
+		},
+		{
+			desc: "alias taken by registered name",
+			do: func(r cqrs.Registry) error {
+				if err := r.Register(Created{}, ""); err != nil {
+					return err
+				}
+				if err := r.Register(Renamed{}, ""); err != nil {
+					return err
+				}
+				return r.Alias("Renamed", "Created")
+			},
+			error: `event "Renamed" already registered`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			err := c.do(cqrs.Registry{})
+			if c.error == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+				t.Fatalf("%q error expected, got %v", c.error, err)
+			}
+		})
+	}
+}
+
+func TestRegistryAliases(t *testing.T) {
+	r := cqrs.Registry{}.New(Created{})
+	if err := r.Alias("Added", "Created"); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.Type("Added")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := v.Interface().(*Created); !ok {
+		t.Fatalf("*Created expected, got %T", v.Interface())
+	}
+
+	if n := r.Names(); len(n) != 2 || n[0] != "Added" || n[1] != "Created" {
+		t.Fatalf("names with aliases expected, got %v", n)
+	}
+
+	if n, err := r.Name(&Created{}); err != nil || n != "Created" {
+		t.Fatalf("registered name of aliased event expected, got %q %v", n, err)
+	}
+
+	if l := r.List(); len(l) != 1 {
+		t.Fatalf("list without aliases expected, got %v", l)
+	}
+
+	if _, err := r.Type("Removed"); err == nil || err.Error() != `event "Removed" not registered` {
+		t.Fatalf("descriptive error expected, got %v", err)
+	}
+
+	if n := cqrs.QualifiedName(&Created{}); n != "cqrs_test.Created" {
+		t.Fatalf("cqrs_test.Created qualified name expected, got %s", n)
+	}
+}
@@ -1,6 +1,7 @@
 package cqrs
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -8,10 +9,20 @@ import (
 	"github.com/sokool/shelf2/internal/platform/cqrs/es"
 )
 
+// ErrNotPublished is returned by Repository.Store, when events were stored,
+// but publisher failed. Command succeeded then, it must not be repeated.
+var ErrNotPublished = errors.New("events stored, but not published")
+
 type Repository struct {
 	store      es.Storage
 	publisher  es.Publisher
 	serializer Serializer
+	events     Registry
+}
+
+// eventNames is AggregateRoot naming its raised events by Registry.
+type eventNames interface {
+	EventNames(Registry)
 }
 
 func NewRepository(s es.Storage, p es.Publisher, m Serializer) *Repository {
@@ -22,12 +33,22 @@ func NewRepository(s es.Storage, p es.Publisher, m Serializer) *Repository {
 	}
 }
 
+// Events sets Registry naming stored events, so they are decoded by Load with
+// the same Registry. Without it events are named like by empty Registry.
+func (r *Repository) Events(events Registry) *Repository { r.events = events; return r }
+
 func (r *Repository) Reader(events Registry, aggregate, id string) *EventReader {
 	return NewEventReader(r.serializer, r.store, events, aggregate, id)
 }
 
+// Load events of aggregate and apply them, events stored under alias are
+// applied under registered name. Aggregate naming its raised events, like
+// Aggregate, gets Registry of Repository, so they are named like by Store.
 func (r *Repository) Load(a AggregateRoot, events Registry) error {
 	id, aggregate, _ := a.Details()
+	if n, ok := a.(eventNames); ok {
+		n.EventNames(r.events)
+	}
 
 	payload, err := r.store.FromVersion(es.Aggregate{
 		ID:   id,
@@ -56,11 +77,12 @@ func (r *Repository) Load(a AggregateRoot, events Registry) error {
 			return fmt.Errorf("meta %s decoding: %s", payload.Events[i].Type, err)
 		}
 
+		n, _ := events.Resolve(d.Type)
 		e := Event{
 			Aggregate: payload.Aggregate,
 			Data:      event.Elem().Interface(),
 			Meta:      m,
-			Type:      d.Type,
+			Type:      n,
 			Version:   d.Version,
 			CreatedAt: d.CreatedAt,
 		}
@@ -73,6 +95,8 @@ func (r *Repository) Load(a AggregateRoot, events Registry) error {
 	return nil
 }
 
+// Store uncommitted events of aggregate under names given by Registry of
+// Repository.
 func (r *Repository) Store(a AggregateRoot, m Meta) error {
 	id, n, version := a.Details()
 	payload := es.AggregateEvents{
@@ -83,8 +107,12 @@ func (r *Repository) Store(a AggregateRoot, m Meta) error {
 
 	date := time.Now()
 	var nn []string
-	for _, event := range a.Uncommitted(true) {
-		en := name(event)
+	for _, event := range a.Uncommitted(false) {
+		en, err := r.events.Name(event)
+		if err != nil {
+			return fmt.Errorf("%s could not store events: %s", n, err)
+		}
+
 		nn = append(nn, en)
 		data, err := r.serializer.Marshal(event)
 		if err != nil {
@@ -106,16 +134,18 @@ func (r *Repository) Store(a AggregateRoot, m Meta) error {
 	}
 
 	if err := r.store.Append(payload, version); err != nil {
-		return fmt.Errorf("%s could not store events: %s", n, err)
+		return fmt.Errorf("%s could not store events: %w", n, err)
 	}
 
+	a.Uncommitted(true)
+
 	if len(nn) > 0 {
 		log.Debug("es.repository", "%s.%s%+v events stored", id, n, nn)
 	}
 
 	if r.publisher != nil {
 		if err := r.publisher.Publish(payload); err != nil {
-			log.Error("cqrs.repository.publisher", err)
+			return fmt.Errorf("%s %w: %s", n, ErrNotPublished, err)
 		}
 	}
 
@@ -0,0 +1,225 @@
+package cqrs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+type book struct {
+	cqrs.Aggregate
+	title   string
+	applied string
+}
+
+func newBook(id string) *book {
+	b := &book{}
+	b.Init(id, "Book", cqrs.EventHandlerFunc(b.apply))
+	return b
+}
+
+func (b *book) apply(e cqrs.Event) error {
+	b.applied = e.Type
+	switch d := e.Data.(type) {
+	case Created:
+		b.title = d.Name
+	case Renamed:
+		b.title = d.Name
+	}
+
+	return nil
+}
+
+// racingStore appends event of other writer before each of first races
+// appends, so they conflict.
+type racingStore struct {
+	es.Storage
+	races int
+}
+
+func (s *racingStore) Append(a es.AggregateEvents, expected uint) error {
+	if s.races > 0 {
+		s.races--
+		o := es.AggregateEvents{Aggregate: a.Aggregate, Events: []es.Event{{Type: "Renamed", Data: []byte(`{"Name":"Other"}`), Meta: []byte(`null`)}}}
+		if err := s.Storage.Append(o, expected); err != nil {
+			return err
+		}
+	}
+
+	return s.Storage.Append(a, expected)
+}
+
+type publisher func(es.AggregateEvents) error
+
+func (p publisher) Publish(a es.AggregateEvents) error { return p(a) }
+
+func TestRepositoryNotPublished(t *testing.T) {
+	down := publisher(func(es.AggregateEvents) error { return fmt.Errorf("broker is down") })
+	r := cqrs.NewRepository(es.NewMemory(), down, cqrs.DefaultSerializer)
+
+	b := newBook("dune")
+	if err := b.Raise(Created{Name: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Store(b, nil); !errors.Is(err, cqrs.ErrNotPublished) {
+		t.Fatalf("ErrNotPublished expected, got %v", err)
+	}
+
+	events := cqrs.Registry{}.New(Created{}, Renamed{})
+	x := cqrs.NewExecutor(r, events, func(id string) cqrs.AggregateRoot { return newBook(id) })
+	res := x.Execute("dune", nil, func(a cqrs.AggregateRoot) error {
+		return a.(*book).Raise(Renamed{Name: "Dune Messiah"})
+	})
+
+	if res.Error != nil || res.Version != 2 {
+		t.Fatalf("command stored in version 2 expected, got %+v", res)
+	}
+
+	l := newBook("dune")
+	if err := r.Load(l, events); err != nil || l.title != "Dune Messiah" {
+		t.Fatalf("stored events expected, got %q %v", l.title, err)
+	}
+}
+
+func TestRepositoryStoreFailure(t *testing.T) {
+	r := cqrs.NewRepository(es.NewMemory(), nil, cqrs.DefaultSerializer)
+
+	b := newBook("dune")
+	if err := b.Raise(Created{Name: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Store(b, nil); err != nil || len(b.Uncommitted(false)) != 0 {
+		t.Fatalf("stored events cleared expected, got %v", err)
+	}
+
+	// stale aggregate, which does not know about stored events.
+	s := newBook("dune")
+	if err := s.Raise(Renamed{Name: "Dune Messiah"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Store(s, nil); !errors.Is(err, es.ErrConcurrency) {
+		t.Fatalf("ErrConcurrency expected, got %v", err)
+	}
+
+	if _, _, v := s.Details(); v != 0 || len(s.Uncommitted(false)) != 1 {
+		t.Fatalf("uncommitted event kept in version 0 expected, got %d in version %d", len(s.Uncommitted(false)), v)
+	}
+}
+
+func TestExecutorConflict(t *testing.T) {
+	cases := []struct {
+		desc     string
+		races    int
+		retries  int
+		attempts int
+		err      error
+	}{
+		{"without conflict", 0, 3, 1, nil},
+		{"conflict retried", 2, 3, 3, nil},
+		{"retries exhausted", 5, 2, 3, es.ErrConcurrency},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			s := &racingStore{Storage: es.NewMemory(), races: c.races}
+			r := cqrs.NewRepository(s, nil, cqrs.DefaultSerializer)
+			x := cqrs.NewExecutor(r, cqrs.Registry{}.New(Created{}, Renamed{}), func(id string) cqrs.AggregateRoot { return newBook(id) }).Retries(c.retries)
+
+			var attempts int
+			res := x.Execute("dune", nil, func(a cqrs.AggregateRoot) error {
+				attempts++
+				return a.(*book).Raise(Renamed{Name: "Dune"})
+			})
+
+			if !errors.Is(res.Error, c.err) || attempts != c.attempts {
+				t.Fatalf("%v after %d attempts expected, got %v after %d", c.err, c.attempts, res.Error, attempts)
+			}
+
+			if c.err == nil && res.Version != uint(c.races+1) {
+				t.Fatalf("command stored after %d events of other writer expected, got version %d", c.races, res.Version)
+			}
+		})
+	}
+}
+
+func TestRepositoryQualifiedNames(t *testing.T) {
+	events := cqrs.Registry{}
+	for _, e := range []interface{}{Created{}, Renamed{}} {
+		if err := events.Register(e, cqrs.QualifiedName(e)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := es.NewMemory()
+	r := cqrs.NewRepository(s, nil, cqrs.DefaultSerializer).Events(events)
+
+	x := cqrs.NewExecutor(r, events, func(id string) cqrs.AggregateRoot { return newBook(id) })
+	res := x.Execute("dune", nil, func(a cqrs.AggregateRoot) error {
+		return a.(*book).Raise(Created{Name: "Dune"})
+	})
+
+	if res.Error != nil || len(res.Events) != 1 || res.Events[0] != "cqrs_test.Created" {
+		t.Fatalf("qualified event name expected, got %+v", res)
+	}
+
+	l, err := s.FromVersion(es.Aggregate{ID: "dune", Type: "Book"}, 0)
+	if err != nil || len(l.Events) != 1 || l.Events[0].Type != "cqrs_test.Created" {
+		t.Fatalf("event stored under qualified name expected, got %+v %v", l, err)
+	}
+
+	b := newBook("dune")
+	if err := r.Load(b, events); err != nil || b.title != "Dune" {
+		t.Fatalf("loaded event expected, got %q %v", b.title, err)
+	}
+
+	// loaded aggregate raises events named by Registry of Repository.
+	if err := b.Raise(Renamed{Name: "Dune Messiah"}); err != nil || b.applied != "cqrs_test.Renamed" {
+		t.Fatalf("event raised under qualified name expected, got %q %v", b.applied, err)
+	}
+
+	if err := b.Raise(struct{ Name string }{"Dune"}); err == nil {
+		t.Fatal("not registered event rejected expected")
+	}
+
+	n := newBook("dune")
+	if err := n.Raise(struct{ Name string }{"Dune"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Store(n, nil); err == nil {
+		t.Fatal("not registered event rejected expected")
+	}
+}
+
+func TestRepositoryAliases(t *testing.T) {
+	s := es.NewMemory()
+	r := cqrs.NewRepository(s, nil, cqrs.DefaultSerializer)
+
+	// Created was stored as Added, before it was renamed.
+	a := es.AggregateEvents{Aggregate: es.Aggregate{ID: "dune", Type: "Book"}, Events: []es.Event{{Type: "Added", Data: []byte(`{"Name":"Dune"}`), Meta: []byte(`null`)}}}
+	if err := s.Append(a, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	events := cqrs.Registry{}.New(Created{})
+	if err := events.Alias("Added", "Created"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBook("dune")
+	if err := r.Load(b, events); err != nil || b.title != "Dune" || b.applied != "Created" {
+		t.Fatalf("Dune created expected, got %q %q %v", b.title, b.applied, err)
+	}
+
+	var types []string
+	h := cqrs.EventHandlerFunc(func(e cqrs.Event) error { types = append(types, e.Type); return nil })
+	if err := r.Reader(events, "Book", "dune").Read(h); err != nil || len(types) != 1 || types[0] != "Created" {
+		t.Fatalf("Created event read expected, got %v %v", types, err)
+	}
+}
@@ -0,0 +1,141 @@
+package cqrs
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/sokool/gokit/log"
+)
+
+// RetryPolicy decides whether handling, which failed for given attempt (counted
+// from 1), should be repeated and how long to wait before next attempt.
+type RetryPolicy interface {
+	Retry(attempt uint, err error) (time.Duration, bool)
+}
+
+type RetryPolicyFunc func(attempt uint, err error) (time.Duration, bool)
+
+func (f RetryPolicyFunc) Retry(attempt uint, err error) (time.Duration, bool) {
+	return f(attempt, err)
+}
+
+// FixedRetry waits the same delay between attempts, giving up after given
+// number of attempts.
+func FixedRetry(delay time.Duration, attempts uint) RetryPolicy {
+	return RetryPolicyFunc(func(attempt uint, err error) (time.Duration, bool) {
+		if attempt >= attempts || IsPermanent(err) {
+			return 0, false
+		}
+
+		return delay, true
+	})
+}
+
+// ExponentialRetry doubles delay after each attempt starting from base, never
+// waiting longer than max. Jitter in range [0, 1] randomizes that portion of
+// delay, so many handlers failing at once do not retry at the same moment,
+// jitter out of that range is clamped to it.
+func ExponentialRetry(base, max time.Duration, attempts uint, jitter float64) RetryPolicy {
+	if jitter < 0 {
+		jitter = 0
+	}
+
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	return RetryPolicyFunc(func(attempt uint, err error) (time.Duration, bool) {
+		if attempt >= attempts || IsPermanent(err) {
+			return 0, false
+		}
+
+		d := base
+		for i := uint(1); i < attempt && d < max; i++ {
+			d *= 2
+		}
+
+		if d > max {
+			d = max
+		}
+
+		if jitter > 0 {
+			j := time.Duration(float64(d) * jitter)
+			d = d - j + time.Duration(rand.Int63n(int64(j)+1))
+		}
+
+		return d, true
+	})
+}
+
+// RetryOn limits policy to errors classified as transient, any other error is
+// not retried.
+func RetryOn(p RetryPolicy, transient func(error) bool) RetryPolicy {
+	return RetryPolicyFunc(func(attempt uint, err error) (time.Duration, bool) {
+		if !transient(err) {
+			return 0, false
+		}
+
+		return p.Retry(attempt, err)
+	})
+}
+
+// Permanent marks error as not worth retrying, regardless of policy.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return permanentError{err}
+}
+
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Retry wraps EventHandler, repeating Handle of failed event according to
+// policy. Next event is not handled until previous one succeeds or policy
+// gives up, so order of events is preserved. Closing done stops waiting for
+// next attempt, then handler gives up as well, nil done never stops.
+func Retry(h EventHandler, p RetryPolicy, done <-chan struct{}) EventHandler {
+	return EventHandlerFunc(func(e Event) error {
+		for attempt := uint(1); ; attempt++ {
+			err := h.Handle(e)
+			if err == nil {
+				return nil
+			}
+
+			wait, ok := p.Retry(attempt, err)
+			if !ok {
+				return &RetryError{Attempts: attempt, Err: err}
+			}
+
+			log.Debug("cqrs.retry", "%s attempt #%d failed, retry after %s: %s", e, attempt, wait, err)
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-done:
+				t.Stop()
+				return &RetryError{Attempts: attempt, Err: err}
+			}
+		}
+	})
+}
+
+// RetryError is returned by handler wrapped with Retry, when policy gives up.
+type RetryError struct {
+	Attempts uint
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *RetryError) Unwrap() error { return e.Err }
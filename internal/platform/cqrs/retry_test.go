@@ -0,0 +1,87 @@
+package cqrs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+)
+
+func TestRetryPolicies(t *testing.T) {
+	failure := fmt.Errorf("connection refused")
+	cases := []struct {
+		desc    string
+		policy  cqrs.RetryPolicy
+		attempt uint
+		err     error
+		min     time.Duration
+		max     time.Duration
+		retry   bool
+	}{
+		{"fixed", cqrs.FixedRetry(time.Second, 3), 2, failure, time.Second, time.Second, true},
+		{"fixed gives up", cqrs.FixedRetry(time.Second, 3), 3, failure, 0, 0, false},
+		{"fixed permanent", cqrs.FixedRetry(time.Second, 3), 1, cqrs.Permanent(failure), 0, 0, false},
+		{"exponential first", cqrs.ExponentialRetry(time.Second, time.Minute, 10, 0), 1, failure, time.Second, time.Second, true},
+		{"exponential doubles", cqrs.ExponentialRetry(time.Second, time.Minute, 10, 0), 4, failure, 8 * time.Second, 8 * time.Second, true},
+		{"exponential max", cqrs.ExponentialRetry(time.Second, time.Minute, 10, 0), 9, failure, time.Minute, time.Minute, true},
+		{"exponential gives up", cqrs.ExponentialRetry(time.Second, time.Minute, 10, 0), 10, failure, 0, 0, false},
+		{"exponential jitter", cqrs.ExponentialRetry(time.Second, time.Minute, 10, 0.5), 2, failure, time.Second, 2 * time.Second, true},
+		{"exponential jitter clamped", cqrs.ExponentialRetry(time.Second, time.Minute, 10, 3), 2, failure, 0, 2 * time.Second, true},
+		{"exponential negative jitter", cqrs.ExponentialRetry(time.Second, time.Minute, 10, -1), 2, failure, 2 * time.Second, 2 * time.Second, true},
+		{"transient", cqrs.RetryOn(cqrs.FixedRetry(time.Second, 3), isRefused), 1, failure, time.Second, time.Second, true},
+		{"not transient", cqrs.RetryOn(cqrs.FixedRetry(time.Second, 3), isRefused), 1, fmt.Errorf("invalid"), 0, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				d, ok := c.policy.Retry(c.attempt, c.err)
+				if ok != c.retry {
+					t.Fatalf("retry %t expected", c.retry)
+				}
+
+				if d < c.min || d > c.max {
+					t.Fatalf("delay in [%s, %s] expected, got %s", c.min, c.max, d)
+				}
+			}
+		})
+	}
+}
+
+func isRefused(err error) bool { return err.Error() == "connection refused" }
+
+func TestRetry(t *testing.T) {
+	var attempts uint
+	failure := fmt.Errorf("connection refused")
+	h := cqrs.EventHandlerFunc(func(cqrs.Event) error {
+		if attempts++; attempts < 3 {
+			return failure
+		}
+
+		return nil
+	})
+
+	if err := cqrs.Retry(h, cqrs.FixedRetry(time.Millisecond, 3), nil).Handle(cqrs.Event{}); err != nil {
+		t.Fatalf("handled event expected, got %s", err)
+	}
+
+	attempts = 0
+	err := cqrs.Retry(h, cqrs.FixedRetry(time.Millisecond, 2), nil).Handle(cqrs.Event{})
+
+	var r *cqrs.RetryError
+	if !errors.As(err, &r) || r.Attempts != 2 || !errors.Is(err, failure) {
+		t.Fatalf("RetryError after 2 attempts expected, got %v", err)
+	}
+
+	done := make(chan struct{})
+	close(done)
+
+	now := time.Now()
+	attempts = 0
+	err = cqrs.Retry(h, cqrs.FixedRetry(time.Hour, 3), done).Handle(cqrs.Event{})
+	if !errors.As(err, &r) || r.Attempts != 1 || time.Since(now) > time.Second {
+		t.Fatalf("RetryError right after first attempt expected, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package cqrs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/sokool/gokit/log"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+// Saga coordinates workflow of many aggregates. It subscribes events like
+// Projection, but every event is handled with state of saga instance, chosen
+// by correlation ID from Meta of event. Commands returned by saga are
+// dispatched after state is saved.
+type Saga interface {
+	Subscriber2
+	// State creates pointer to empty state of saga instance.
+	State() interface{}
+	// Handle event, changing state. Done ends saga instance, next events
+	// correlated with it are ignored.
+	Handle(state interface{}, e Event) (cc []SagaCommand, done bool, err error)
+}
+
+// SagaCommand is command sent by saga to aggregate of given ID.
+type SagaCommand struct {
+	ID      string
+	Command interface{}
+}
+
+// ProcessManager runs sagas. Their states and outboxes of commands are kept in
+// es.SagaStore, so commands not dispatched before restart are dispatched by
+// Recover. Command is dispatched at least once, so its handler should be
+// idempotent.
+type ProcessManager struct {
+	subscriber  *Subscriber
+	store       es.SagaStore
+	handler     CommandHandler
+	commands    CommandFactory
+	serializer  Serializer
+	correlation string
+	retries     int
+}
+
+// NewProcessManager creates ProcessManager subscribing sagas with Subscriber,
+// and dispatching their commands to CommandHandler, commands read from outbox
+// are decoded into values created by CommandFactory, like CommandBus.Command.
+func NewProcessManager(s *Subscriber, st es.SagaStore, h CommandHandler, f CommandFactory, m Serializer) *ProcessManager {
+	return &ProcessManager{
+		subscriber:  s,
+		store:       st,
+		handler:     h,
+		commands:    f,
+		serializer:  m,
+		correlation: "Correlation-Id",
+		retries:     3,
+	}
+}
+
+// Correlation sets Meta key of correlation ID, default is Correlation-Id.
+// Event without it is correlated by ID of its aggregate.
+func (p *ProcessManager) Correlation(key string) *ProcessManager { p.correlation = key; return p }
+
+// Retries sets how many times event is handled again on concurrent change of
+// saga state.
+func (p *ProcessManager) Retries(n int) *ProcessManager { p.retries = n; return p }
+
+// Register subscribes saga.
+func (p *ProcessManager) Register(s Saga) error {
+	return p.subscriber.Subscribe(&sagaProjection{saga: s, manager: p, name: name(s)})
+}
+
+// Recover dispatches commands left in outboxes of saga.
+func (p *ProcessManager) Recover(s Saga) error {
+	n := name(s)
+	ss, err := p.store.Pending(n)
+	if err != nil {
+		return err
+	}
+
+	for _, st := range ss {
+		if err := p.dispatch(n, st.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *ProcessManager) handle(n string, s Saga, e Event) error {
+	id := e.Meta[p.correlation]
+	if id == "" {
+		id = e.Aggregate.ID
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := p.apply(n, id, s, e)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, es.ErrConcurrency) || attempt >= p.retries {
+			return err
+		}
+	}
+
+	return p.dispatch(n, id)
+}
+
+// apply event to state of saga instance and saves it with commands in
+// outbox.
+func (p *ProcessManager) apply(n, id string, s Saga, e Event) error {
+	st, ok, err := p.store.Load(n, id)
+	if err != nil {
+		return err
+	}
+
+	a := e.Aggregate.Type + "." + e.Aggregate.ID
+	if st.Done || st.IsProcessed(a, e.Version) {
+		return nil
+	}
+
+	state := s.State()
+	if ok {
+		if err := p.serializer.Unmarshal(st.Data, state); err != nil {
+			return fmt.Errorf("%s.%s saga state decoding: %s", n, id, err)
+		}
+	}
+
+	cc, done, err := s.Handle(state, e)
+	if err != nil {
+		return err
+	}
+
+	expected := st.Version
+	st.Saga, st.ID, st.Done = n, id, done
+	processed := map[string]uint{a: e.Version}
+	for k, v := range st.Processed {
+		if k != a {
+			processed[k] = v
+		}
+	}
+
+	st.Processed = processed
+	if st.Data, err = p.serializer.Marshal(state); err != nil {
+		return fmt.Errorf("%s.%s saga state encoding: %s", n, id, err)
+	}
+
+	key := fmt.Sprintf("%s.%s.%d", a, e.Type, e.Version)
+	meta, err := p.serializer.Marshal(Meta{p.correlation: id, "Causation-Id": key})
+	if err != nil {
+		return err
+	}
+
+	for i, c := range cc {
+		data, err := p.serializer.Marshal(c.Command)
+		if err != nil {
+			return fmt.Errorf("%s.%s saga %s command encoding: %s", n, id, name(c.Command), err)
+		}
+
+		st.Pending = append(st.Pending, es.SagaCommand{
+			ID:        fmt.Sprintf("%s.%d", key, i),
+			Aggregate: c.ID,
+			Name:      name(c.Command),
+			Data:      data,
+			Meta:      meta,
+		})
+	}
+
+	return p.store.Save(st, expected)
+}
+
+// dispatch commands from outbox of saga instance, removing them one by one.
+// Command rejected by domain or validation is removed too, other failures
+// leave it for next dispatch.
+func (p *ProcessManager) dispatch(n, id string) error {
+	for {
+		st, ok, err := p.store.Load(n, id)
+		if err != nil || !ok || len(st.Pending) == 0 {
+			return err
+		}
+
+		c := st.Pending[0]
+		v, err := p.commands(c.Name)
+		if err != nil {
+			return fmt.Errorf("%s.%s saga: %s", n, id, err)
+		}
+
+		if err := p.serializer.Unmarshal(c.Data, v); err != nil {
+			return fmt.Errorf("%s.%s saga %s command decoding: %s", n, id, c.Name, err)
+		}
+
+		var m Meta
+		if err := p.serializer.Unmarshal(c.Meta, &m); err != nil {
+			return fmt.Errorf("%s.%s saga %s meta decoding: %s", n, id, c.Name, err)
+		}
+
+		r := p.handler.Handle(c.Aggregate, reflect.ValueOf(v).Elem().Interface(), m)
+		var d DomainError
+		switch {
+		case r.Error == nil, errors.Is(r.Error, ErrNotPublished):
+			log.Debug("cqrs.saga", "%s.%s dispatched %s to %s", n, id, c.Name, c.Aggregate)
+		case errors.As(r.Error, &d) || errors.Is(r.Error, ErrValidation):
+			log.Error("cqrs.saga", fmt.Errorf("%s.%s %s command rejected: %w", n, id, c.Name, r.Error))
+		default:
+			return fmt.Errorf("%s.%s saga %s command: %w", n, id, c.Name, r.Error)
+		}
+
+		st.Pending = st.Pending[1:]
+		if err := p.store.Save(st, st.Version); err != nil && !errors.Is(err, es.ErrConcurrency) {
+			return err
+		}
+	}
+}
+
+// sagaProjection subscribes Saga with Subscriber, so it gets decoding, retries
+// and dead letters of projections.
+type sagaProjection struct {
+	saga    Saga
+	manager *ProcessManager
+	name    string
+}
+
+func (s *sagaProjection) Type() string { return s.name }
+
+func (s *sagaProjection) Subscribe(ss Subscriptions) error { return s.saga.Subscribe(ss) }
+
+func (s *sagaProjection) Handle(e Event) error { return s.manager.handle(s.name, s.saga, e) }
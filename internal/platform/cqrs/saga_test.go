@@ -0,0 +1,98 @@
+package cqrs_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+type lending struct{}
+
+type lendingState struct{ Events []string }
+
+func (lending) Type() string { return "lending" }
+
+func (lending) Subscribe(ss cqrs.Subscriptions) error {
+	return ss.Assign("Book", Created{}, Renamed{})
+}
+
+func (lending) State() interface{} { return &lendingState{} }
+
+func (lending) Handle(state interface{}, e cqrs.Event) ([]cqrs.SagaCommand, bool, error) {
+	s := state.(*lendingState)
+	s.Events = append(s.Events, e.Type)
+
+	switch d := e.Data.(type) {
+	case Created:
+		return []cqrs.SagaCommand{{ID: e.Aggregate.ID, Command: Rename{Name: d.Name + " (lent)"}}}, false, nil
+	case Renamed:
+		return nil, true, nil
+	}
+
+	return nil, false, nil
+}
+
+func TestProcessManager(t *testing.T) {
+	ps := es.NewMemPubSub(es.MemSynchronous())
+	defer ps.Close()
+
+	var sent []string
+	down := true
+	h := cqrs.CommandHandlerFunc(func(id string, c interface{}, m cqrs.Meta) cqrs.Response {
+		if down {
+			return cqrs.Response{ID: id, Error: fmt.Errorf("database is down")}
+		}
+
+		sent = append(sent, fmt.Sprintf("%s:%s:%s", id, c.(Rename).Name, m["Causation-Id"]))
+		return cqrs.Response{ID: id}
+	})
+
+	b := cqrs.NewCommandBus()
+	if err := b.Register(Rename{}, h); err != nil {
+		t.Fatal(err)
+	}
+
+	st := es.NewMemSagas()
+	p := cqrs.NewProcessManager(cqrs.NewSubscriber(ps, cqrs.DefaultSerializer), st, b, b.Command, cqrs.DefaultSerializer)
+	if err := p.Register(lending{}); err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(typ string, v uint) {
+		ps.Publish(es.AggregateEvents{
+			Aggregate: es.Aggregate{ID: "dune", Type: "Book"},
+			Events:    []es.Event{{Type: typ, Version: v, Data: []byte(`{"Name":"Dune"}`)}},
+		})
+	}
+
+	// command stays in outbox, when it can not be dispatched.
+	publish("Created", 1)
+	if s, _, _ := st.Load("lending", "dune"); len(s.Pending) != 1 || len(sent) != 0 {
+		t.Fatalf("command in outbox expected, got %+v", s)
+	}
+
+	down = false
+	if err := p.Recover(lending{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// redelivered event is not handled again.
+	publish("Created", 1)
+	if len(sent) != 1 || sent[0] != "dune:Dune (lent):Book.dune.Created.1" {
+		t.Fatalf("one command expected, got %v", sent)
+	}
+
+	publish("Renamed", 2)
+	publish("Created", 3)
+
+	s, _, err := st.Load("lending", "dune")
+	if err != nil || !s.Done || len(s.Pending) != 0 || s.Processed["Book.dune"] != 2 || len(sent) != 1 {
+		t.Fatalf("saga done after Renamed expected, got %+v, %v", s, sent)
+	}
+
+	if string(s.Data) != `{"Events":["Created","Renamed"]}` {
+		t.Fatalf("state of handled events expected, got %s", s.Data)
+	}
+}
@@ -0,0 +1,275 @@
+package cqrs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/sokool/gokit/log"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+// Clock tells time to Scheduler, FakeClock replaces it in tests.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is Clock of real time.
+func SystemClock() Clock { return systemClock{} }
+
+// FakeClock is Clock which time moves only by Add.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		return w.c
+	}
+
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+// Add moves time forward, waking waiters which time has come.
+func (c *FakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	var ww []fakeWaiter
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			ww = append(ww, w)
+			continue
+		}
+
+		w.c <- c.now
+	}
+
+	c.waiters = ww
+}
+
+// Scheduler sends commands to CommandHandler at scheduled time, with Meta
+// given when they were scheduled. Commands are kept in es.Schedule, so they
+// survive restarts. Due commands are leased by Scheduler which sends them, so
+// many Schedulers might run, command is sent at least once, again when lease
+// expires before it is done.
+type Scheduler struct {
+	schedule   es.Schedule
+	handler    CommandHandler
+	commands   CommandFactory
+	serializer Serializer
+	clock      Clock
+	interval   time.Duration
+	lease      time.Duration
+	retry      RetryPolicy
+	wakeup     chan struct{}
+}
+
+// NewScheduler creates Scheduler, commands are decoded into values created by
+// CommandFactory, like CommandBus.Command. Failed commands are retried 5 times
+// every minute, unless they are rejected by domain or validation.
+func NewScheduler(s es.Schedule, h CommandHandler, f CommandFactory, m Serializer) *Scheduler {
+	return &Scheduler{
+		schedule:   s,
+		handler:    h,
+		commands:   f,
+		serializer: m,
+		clock:      SystemClock(),
+		interval:   time.Minute,
+		lease:      5 * time.Minute,
+		retry:      FixedRetry(time.Minute, 5),
+		wakeup:     make(chan struct{}, 1),
+	}
+}
+
+func (s *Scheduler) Clock(c Clock) *Scheduler { s.clock = c; return s }
+
+// Interval sets longest time Scheduler sleeps without checking schedule, so
+// it notices commands scheduled by other processes.
+func (s *Scheduler) Interval(d time.Duration) *Scheduler { s.interval = d; return s }
+
+func (s *Scheduler) Retries(p RetryPolicy) *Scheduler { s.retry = p; return s }
+
+// Lease sets how long due commands are reserved for Scheduler sending them,
+// it should be longer than sending of 100 commands. Default is 5 minutes.
+func (s *Scheduler) Lease(d time.Duration) *Scheduler { s.lease = d; return s }
+
+// At schedules command to aggregate of given ID, replacing command scheduled
+// with the same key.
+func (s *Scheduler) At(key string, at time.Time, id string, command interface{}, m Meta) error {
+	data, err := s.serializer.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("%s command encoding: %s", name(command), err)
+	}
+
+	meta, err := s.serializer.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("%s meta encoding: %s", name(command), err)
+	}
+
+	err = s.schedule.Schedule(es.Scheduled{
+		Key:       key,
+		Aggregate: id,
+		Command:   name(command),
+		Data:      data,
+		Meta:      meta,
+		At:        at,
+		CreatedAt: s.clock.Now(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.wake()
+	return nil
+}
+
+// After schedules command in given duration from now.
+func (s *Scheduler) After(key string, d time.Duration, id string, command interface{}, m Meta) error {
+	return s.At(key, s.clock.Now().Add(d), id, command, m)
+}
+
+// Cancel scheduled command.
+func (s *Scheduler) Cancel(key string) error {
+	return s.schedule.Cancel(key)
+}
+
+// Run sends due commands until context is done.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		if _, err := s.Fire(); err != nil {
+			log.Error("cqrs.scheduler", err)
+		}
+
+		d := s.interval
+		if next, ok, err := s.schedule.Next(); err != nil {
+			log.Error("cqrs.scheduler", err)
+		} else if ok && next.Sub(s.clock.Now()) < d {
+			d = next.Sub(s.clock.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.wakeup:
+		case <-s.clock.After(d):
+		}
+	}
+}
+
+// Fire sends commands which time has come, returning how many were sent.
+func (s *Scheduler) Fire() (int, error) {
+	var n int
+	for {
+		cc, err := s.schedule.Due(s.clock.Now(), s.lease, 100)
+		if err != nil {
+			return n, err
+		}
+
+		if len(cc) == 0 {
+			return n, nil
+		}
+
+		for _, c := range cc {
+			if err := s.send(c); err != nil {
+				log.Error("cqrs.scheduler", fmt.Errorf("%s: %w", c.Key, err))
+				if err := s.reschedule(c, err); err != nil {
+					return n, err
+				}
+
+				continue
+			}
+
+			n++
+			if err := s.schedule.Done(c); err != nil {
+				return n, err
+			}
+		}
+	}
+}
+
+func (s *Scheduler) send(c es.Scheduled) error {
+	v, err := s.commands(c.Command)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	if err := s.serializer.Unmarshal(c.Data, v); err != nil {
+		return Permanent(fmt.Errorf("%s command decoding: %s", c.Command, err))
+	}
+
+	var m Meta
+	if len(c.Meta) > 0 {
+		if err := s.serializer.Unmarshal(c.Meta, &m); err != nil {
+			return Permanent(fmt.Errorf("%s meta decoding: %s", c.Command, err))
+		}
+	}
+
+	r := s.handler.Handle(c.Aggregate, reflect.ValueOf(v).Elem().Interface(), m)
+	if errors.Is(r.Error, ErrNotPublished) {
+		log.Error("cqrs.scheduler", r.Error)
+		return nil
+	}
+
+	var d DomainError
+	if errors.As(r.Error, &d) || errors.Is(r.Error, ErrValidation) {
+		return Permanent(r.Error)
+	}
+
+	return r.Error
+}
+
+// reschedule failed command according to retry policy, or drop it. Command
+// replaced in meantime is left untouched.
+func (s *Scheduler) reschedule(c es.Scheduled, err error) error {
+	c.Attempts++
+	d, ok := s.retry.Retry(c.Attempts, err)
+	if !ok {
+		log.Info("cqrs.scheduler", "%s command %s dropped after %d attempts", c.Key, c.Command, c.Attempts)
+		return s.schedule.Done(c)
+	}
+
+	return s.schedule.Retry(c, s.clock.Now().Add(d))
+}
+
+func (s *Scheduler) wake() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,151 @@
+package cqrs_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestScheduler(t *testing.T) {
+	c := cqrs.NewFakeClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	st := es.NewMemSchedule()
+
+	var s *cqrs.Scheduler
+	var sent []string
+	var fail error
+	h := cqrs.CommandHandlerFunc(func(id string, v interface{}, m cqrs.Meta) cqrs.Response {
+		if fail != nil {
+			// command is replaced while it is sent.
+			if err := s.After("return", time.Hour, id, Rename{Name: "Emma"}, m); err != nil {
+				return cqrs.Response{ID: id, Error: err}
+			}
+
+			return cqrs.Response{ID: id, Error: fail}
+		}
+
+		sent = append(sent, fmt.Sprintf("%s:%s:%s", id, v.(Rename).Name, m["User"]))
+		return cqrs.Response{ID: id}
+	})
+
+	b := cqrs.NewCommandBus()
+	if err := b.Register(Rename{}, h); err != nil {
+		t.Fatal(err)
+	}
+
+	s = cqrs.NewScheduler(st, b, b.Command, cqrs.DefaultSerializer).Clock(c).Retries(cqrs.FixedRetry(time.Minute, 3))
+	fire := func(n int) {
+		t.Helper()
+		if m, err := s.Fire(); err != nil || m != n {
+			t.Fatalf("%d commands sent expected, got %d %v", n, m, err)
+		}
+	}
+
+	if err := s.After("return", time.Hour, "dune", Rename{Name: "Dune"}, cqrs.Meta{"User": "tom"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fire(0)
+	c.Add(time.Hour)
+	fire(1)
+	fire(0)
+
+	if len(sent) != 1 || sent[0] != "dune:Dune:tom" {
+		t.Fatalf("one command expected, got %v", sent)
+	}
+
+	// retry of failed command does not overwrite command replacing it.
+	if err := s.After("return", time.Hour, "dune", Rename{Name: "Dune"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Add(time.Hour)
+	fail = fmt.Errorf("database is down")
+	fire(0)
+
+	fail = nil
+	c.Add(time.Minute)
+	fire(0)
+	c.Add(time.Hour)
+	fire(1)
+
+	if len(sent) != 2 || sent[1] != "dune:Emma:" {
+		t.Fatalf("replacing command expected, got %v", sent)
+	}
+}
+
+func TestSchedulerLease(t *testing.T) {
+	c := cqrs.NewFakeClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	st := es.NewMemSchedule()
+
+	if err := st.Schedule(es.Scheduled{Key: "return", Aggregate: "dune", Command: "Rename", At: c.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if cc, err := st.Due(c.Now(), time.Minute, 10); err != nil || len(cc) != 1 {
+		t.Fatalf("leased command expected, got %v %v", cc, err)
+	}
+
+	if cc, err := st.Due(c.Now(), time.Minute, 10); err != nil || len(cc) != 0 {
+		t.Fatalf("no commands for other scheduler expected, got %v %v", cc, err)
+	}
+
+	c.Add(time.Minute)
+	if cc, err := st.Due(c.Now(), time.Minute, 10); err != nil || len(cc) != 1 {
+		t.Fatalf("command of expired lease expected, got %v %v", cc, err)
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	c := cqrs.NewFakeClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	sent := make(chan string, 1)
+	h := cqrs.CommandHandlerFunc(func(id string, v interface{}, m cqrs.Meta) cqrs.Response {
+		sent <- id
+		return cqrs.Response{ID: id}
+	})
+
+	b := cqrs.NewCommandBus()
+	if err := b.Register(Rename{}, h); err != nil {
+		t.Fatal(err)
+	}
+
+	s := cqrs.NewScheduler(es.NewMemSchedule(), b, b.Command, cqrs.DefaultSerializer).Clock(c)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	if err := s.After("return", time.Hour, "dune", Rename{Name: "Dune"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run might start waiting after time is moved, so it is moved until
+	// command is sent.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		c.Add(time.Minute)
+		select {
+		case id := <-sent:
+			if id != "dune" {
+				t.Fatalf("dune expected, got %s", id)
+			}
+		case <-time.After(time.Millisecond):
+			if time.Now().After(deadline) {
+				t.Fatal("command sent expected")
+			}
+			continue
+		}
+
+		break
+	}
+
+	if c.Now().Before(time.Date(2020, 1, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Fatalf("command sent at scheduled time expected, got %s", c.Now())
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("context.Canceled expected, got %v", err)
+	}
+}
@@ -1,7 +1,9 @@
 package cqrs
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/sokool/gokit/log"
 	"github.com/sokool/shelf2/internal/platform/cqrs/es"
@@ -36,64 +38,209 @@ func (s Subscriptions) Has(e Event) bool {
 }
 
 type Subscriber struct {
-	subscriber es.Subscriber
-	serializer Serializer
+	subscriber  es.Subscriber
+	serializer  Serializer
+	deadLetters es.DeadLetters
+	retry       RetryPolicy
+	instance    string
+	done        chan struct{}
+
+	mu          sync.Mutex
+	projections map[string]subscription
+}
+
+type subscription struct {
+	projection    Projection
+	subscriptions Subscriptions
 }
 
 func NewSubscriber(s es.Subscriber, m Serializer) *Subscriber {
 	return &Subscriber{
-		subscriber: s,
-		serializer: m,
+		subscriber:  s,
+		serializer:  m,
+		done:        make(chan struct{}),
+		projections: make(map[string]subscription),
 	}
 }
 
-func (p *Subscriber) Subscribe(h Projection) error {
+// DeadLetters parks events which projections failed to decode or handle in
+// given storage, instead of dropping them.
+func (p *Subscriber) DeadLetters(d es.DeadLetters) *Subscriber { p.deadLetters = d; return p }
+
+// Retries repeats handling of events which projections failed to handle,
+// according to given policy, before they are parked.
+func (p *Subscriber) Retries(r RetryPolicy) *Subscriber { p.retry = r; return p }
 
+// Instance makes every projection a member of consumer group named after it,
+// where given instance ID names member. Replicas of projection with different
+// instance IDs share events partitioned by aggregate ID.
+func (p *Subscriber) Instance(id string) *Subscriber { p.instance = id; return p }
+
+// Subscribe projection to events it assigns to Subscriptions. Name of
+// projection identifies its dead letters, so it has to be unique.
+func (p *Subscriber) Subscribe(h Projection) error {
+	n := name(h)
 	ss := Subscriptions{}
-	h.Subscribe(ss)
+	if err := h.Subscribe(ss); err != nil {
+		return fmt.Errorf("%s projection: %w", n, err)
+	}
 
-	handler := func(a es.Aggregate, e es.Event) {
-		events, ok := ss[a.Type]
-		if !ok {
-			log.Error("cqrs", fmt.Errorf("subscription %events not found", a.Type))
-			return
-		}
+	p.mu.Lock()
+	if _, ok := p.projections[n]; ok {
+		p.mu.Unlock()
+		return fmt.Errorf("%s projection already subscribed", n)
+	}
+	p.projections[n] = subscription{projection: h, subscriptions: ss}
+	p.mu.Unlock()
 
-		evt, err := events.Type(e.Type)
-		if err != nil {
-			log.Error("cqrs", err)
-			return
+	handler := func(a es.Aggregate, e es.Event) error {
+		if err := p.handle(h, ss, a, e); err != nil {
+			return p.park(n, a, e, err)
 		}
 
-		v := evt.Interface()
-		if err := p.serializer.Unmarshal(e.Data, &v); err != nil {
-			log.Error("cqrs", fmt.Errorf("unmarshal %events %s", a.Type, err))
-			return
+		return nil
+	}
+
+	z := es.NewSubscription(handler).Name(n)
+	if p.instance != "" {
+		z.Name(n + "." + p.instance).Group(n)
+	}
+
+	for aggregate, s := range ss {
+		z.AggregateEvents(aggregate, s.Names()...)
+	}
+
+	return p.subscriber.Subscribe(*z)
+}
+
+// Close stops retries waiting for next attempt, so subscriber is able to shut
+// down, events which handling was stopped are parked. It does not close
+// es.Subscriber.
+func (p *Subscriber) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+
+	return nil
+}
+
+// Parked lists events parked by given projection, or by all projections when
+// name is empty.
+func (p *Subscriber) Parked(projection string) ([]es.DeadLetter, error) {
+	if p.deadLetters == nil {
+		return nil, fmt.Errorf("dead letters storage not set")
+	}
+
+	return p.deadLetters.List(projection)
+}
+
+// Retry delivers parked event once again to projection which failed to
+// process it. Event is removed from dead letters when projection handles it,
+// otherwise it stays parked with increased attempts counter.
+func (p *Subscriber) Retry(id string) error {
+	if p.deadLetters == nil {
+		return fmt.Errorf("dead letters storage not set")
+	}
+
+	d, err := p.deadLetters.Get(id)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	s, ok := p.projections[d.Subscriber]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s projection is not subscribed", d.Subscriber)
+	}
+
+	if err := p.handle(s.projection, s.subscriptions, d.Aggregate, d.Event); err != nil {
+		if perr := p.park(d.Subscriber, d.Aggregate, d.Event, err); perr != nil {
+			return fmt.Errorf("%w, parking again failed: %s", err, perr)
 		}
 
-		var m map[string]string
-		if len(e.Meta) > 0 {
-			if err := p.serializer.Unmarshal(e.Meta, &m); err != nil {
-				//return err
-			}
+		return err
+	}
+
+	return p.deadLetters.Delete(id)
+}
+
+// Discard removes parked event without delivering it.
+func (p *Subscriber) Discard(id string) error {
+	if p.deadLetters == nil {
+		return fmt.Errorf("dead letters storage not set")
+	}
+
+	if _, err := p.deadLetters.Get(id); err != nil {
+		return err
+	}
+
+	return p.deadLetters.Delete(id)
+}
+
+func (p *Subscriber) handle(h Projection, ss Subscriptions, a es.Aggregate, e es.Event) error {
+	events, ok := ss[a.Type]
+	if !ok {
+		return fmt.Errorf("subscription %s not found", a.Type)
+	}
+
+	evt, err := events.Type(e.Type)
+	if err != nil {
+		return err
+	}
+
+	v := evt.Interface()
+	if err := p.serializer.Unmarshal(e.Data, &v); err != nil {
+		return fmt.Errorf("unmarshal %s.%s %s", a.Type, e.Type, err)
+	}
+
+	var m map[string]string
+	if len(e.Meta) > 0 {
+		if err := p.serializer.Unmarshal(e.Meta, &m); err != nil {
+			return fmt.Errorf("unmarshal %s.%s meta %s", a.Type, e.Type, err)
 		}
+	}
 
-		h.Handle(Event{
-			Aggregate: a,
-			Data:      evt.Elem().Interface(),
-			Meta:      m,
-			Type:      e.Type,
-			Version:   e.Version,
-			CreatedAt: e.CreatedAt,
-		})
+	var eh EventHandler = h
+	if p.retry != nil {
+		eh = Retry(h, p.retry, p.done)
+	}
 
+	// event published under alias is handled under registered name.
+	n, _ := events.Resolve(e.Type)
+	return eh.Handle(Event{
+		Aggregate: a,
+		Data:      evt.Elem().Interface(),
+		Meta:      m,
+		Type:      n,
+		Version:   e.Version,
+		CreatedAt: e.CreatedAt,
+	})
+}
+
+// park stores failed event in dead letters. Error is returned when event could
+// not be parked, so es.Subscriber is able to redeliver it.
+func (p *Subscriber) park(projection string, a es.Aggregate, e es.Event, err error) error {
+	d := es.NewDeadLetter(projection, a, e, err)
+	var r *RetryError
+	if errors.As(err, &r) {
+		d.Attempts = r.Attempts
 	}
 
-	z := es.NewSubscription(handler)
-	for aggregate, s := range ss {
-		z.Name(name(h)).
-			AggregateEvents(aggregate, s.Names()...)
+	log.Error("cqrs", fmt.Errorf("%s %s", d.Subscriber, d.Error))
+	if p.deadLetters == nil {
+		return err
 	}
 
-	return p.subscriber.Subscribe(*z)
+	if err := p.deadLetters.Park(d); err != nil {
+		log.Error("cqrs.dead_letters", fmt.Errorf("%s could not be parked: %s", d.ID, err))
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,371 @@
+package cqrs
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sokool/gokit/log"
+)
+
+// SQLDialect writes statements of TableProjection for one database.
+type SQLDialect struct {
+	quote    string
+	numbered bool
+	upsert   func(d SQLDialect, table string, key, columns []string) string
+}
+
+var (
+	MySQLDialect = SQLDialect{
+		quote: "`",
+		upsert: func(d SQLDialect, table string, key, columns []string) string {
+			var set []string
+			for _, c := range columns {
+				set = append(set, fmt.Sprintf("%s = VALUES(%s)", d.ident(c), d.ident(c)))
+			}
+
+			return d.insert(table, columns) + " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+		},
+	}
+
+	PostgresDialect = SQLDialect{
+		quote:    `"`,
+		numbered: true,
+		upsert:   onConflict,
+	}
+
+	SQLiteDialect = SQLDialect{
+		quote:  `"`,
+		upsert: onConflict,
+	}
+)
+
+func onConflict(d SQLDialect, table string, key, columns []string) string {
+	var set []string
+	for _, c := range columns {
+		set = append(set, fmt.Sprintf("%s = excluded.%s", d.ident(c), d.ident(c)))
+	}
+
+	var kk []string
+	for _, k := range key {
+		kk = append(kk, d.ident(k))
+	}
+
+	q := d.insert(table, columns) + fmt.Sprintf(" ON CONFLICT (%s) DO ", strings.Join(kk, ", "))
+	if len(set) == 0 {
+		return q + "NOTHING"
+	}
+
+	return q + "UPDATE SET " + strings.Join(set, ", ")
+}
+
+func (d SQLDialect) ident(n string) string {
+	return d.quote + strings.Replace(n, d.quote, d.quote+d.quote, -1) + d.quote
+}
+
+func (d SQLDialect) insert(table string, columns []string) string {
+	var cc, pp []string
+	for _, c := range columns {
+		cc = append(cc, d.ident(c))
+		pp = append(pp, "?")
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", d.ident(table), strings.Join(cc, ", "), strings.Join(pp, ", "))
+}
+
+// rebind ? placeholders to $n, when dialect numbers them.
+func (d SQLDialect) rebind(q string) string {
+	if !d.numbered {
+		return q
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Row is set of column values written by TableProjection.
+type Row map[string]interface{}
+
+// TableProjection is Projection kept in one SQL table. Events are mapped to
+// upsert or delete of row, every event is applied in transaction together
+// with checkpoint of its aggregate, so event delivered again is skipped.
+// Checkpoint remembers key of row last written by aggregate, so Delete finds
+// aggregates producing row. Events are named like by Repository, when Events
+// is given same Registry before handlers are added.
+//
+//	p := cqrs.NewTableProjection(db, cqrs.MySQLDialect, "books").
+//		Column("id", "varchar(64) NOT NULL").
+//		Column("title", "varchar(255)").
+//		OnUpsert("Book", Created{}, func(e cqrs.Event) (cqrs.Row, error) {
+//			return cqrs.Row{"id": e.Aggregate.ID, "title": e.Data.(Created).Title}, nil
+//		}).
+//		OnDelete("Book", Removed{}, nil)
+type TableProjection struct {
+	db       *sql.DB
+	dialect  SQLDialect
+	name     string
+	table    string
+	key      string
+	columns  []string
+	types    map[string]string
+	handlers map[string]func(*sql.Tx, Event) (string, error)
+	events   Subscriptions
+	registry Registry
+	err      error
+}
+
+func NewTableProjection(db *sql.DB, d SQLDialect, table string) *TableProjection {
+	return &TableProjection{
+		db:       db,
+		dialect:  d,
+		name:     table,
+		table:    table,
+		types:    make(map[string]string),
+		handlers: make(map[string]func(*sql.Tx, Event) (string, error)),
+		events:   Subscriptions{},
+	}
+}
+
+// Name of projection, used by Subscriber and checkpoints, default is name of
+// table.
+func (t *TableProjection) Name(n string) *TableProjection { t.name = n; return t }
+
+// Events sets Registry naming events of handlers added after it.
+func (t *TableProjection) Events(r Registry) *TableProjection { t.registry = r; return t }
+
+// Column adds column of given SQL type to schema, first column is key, unless
+// Key is set.
+func (t *TableProjection) Column(name, typ string) *TableProjection {
+	if t.key == "" {
+		t.key = name
+	}
+
+	if _, ok := t.types[name]; !ok {
+		t.columns = append(t.columns, name)
+	}
+
+	t.types[name] = typ
+	return t
+}
+
+// Key sets column identifying row, it is used by Purger.
+func (t *TableProjection) Key(column string) *TableProjection { t.key = column; return t }
+
+// OnUpsert writes row returned by f for event of aggregate, row has to
+// contain key, columns missing in row are not changed.
+func (t *TableProjection) OnUpsert(aggregate string, event interface{}, f func(Event) (Row, error)) *TableProjection {
+	return t.on(aggregate, event, func(tx *sql.Tx, e Event) (string, error) {
+		r, err := f(e)
+		if err != nil {
+			return "", err
+		}
+
+		k, ok := r[t.key]
+		if !ok {
+			return "", fmt.Errorf("%s row without %s key", t.table, t.key)
+		}
+
+		var cc []string
+		for c := range r {
+			if _, ok := t.types[c]; !ok {
+				return "", fmt.Errorf("%s table has no %s column", t.table, c)
+			}
+			cc = append(cc, c)
+		}
+
+		sort.Strings(cc)
+		var vv []interface{}
+		for _, c := range cc {
+			vv = append(vv, r[c])
+		}
+
+		_, err = tx.Exec(t.dialect.rebind(t.dialect.upsert(t.dialect, t.table, []string{t.key}, cc)), vv...)
+		return fmt.Sprint(k), err
+	})
+}
+
+// OnDelete removes row of key returned by f for event of aggregate, nil f
+// takes aggregate ID as key.
+func (t *TableProjection) OnDelete(aggregate string, event interface{}, f func(Event) (string, error)) *TableProjection {
+	return t.on(aggregate, event, func(tx *sql.Tx, e Event) (string, error) {
+		id := e.Aggregate.ID
+		if f != nil {
+			var err error
+			if id, err = f(e); err != nil {
+				return "", err
+			}
+		}
+
+		_, err := tx.Exec(t.dialect.rebind(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", t.dialect.ident(t.table), t.dialect.ident(t.key))), id)
+		return id, err
+	})
+}
+
+// on registers handler of event under name given by Registry, first error is
+// returned by Subscribe and Create.
+func (t *TableProjection) on(aggregate string, event interface{}, h func(*sql.Tx, Event) (string, error)) *TableProjection {
+	if t.err != nil {
+		return t
+	}
+
+	n, err := t.registry.Name(event)
+	if err == nil {
+		if t.events[aggregate] == nil {
+			t.events[aggregate] = Registry{}
+		}
+
+		err = t.events[aggregate].Register(event, n)
+	}
+
+	if err != nil {
+		t.err = fmt.Errorf("%s projection %s: %w", t.name, aggregate, err)
+		return t
+	}
+
+	t.handlers[aggregate+"."+n] = h
+	return t
+}
+
+func (t *TableProjection) Type() string { return t.name }
+
+func (t *TableProjection) Subscribe(ss Subscriptions) error {
+	if t.err != nil {
+		return t.err
+	}
+
+	// events keep names given by Registry.
+	for a, r := range t.events {
+		if ss[a] == nil {
+			ss[a] = Registry{}
+		}
+
+		for _, n := range r.Names() {
+			if err := ss[a].Register(r[n], n); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Handle applies event and checkpoint of its aggregate in one transaction.
+func (t *TableProjection) Handle(e Event) error {
+	h, ok := t.handlers[e.Aggregate.Type+"."+e.Type]
+	if !ok {
+		return nil
+	}
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var v uint
+	err = tx.QueryRow(t.dialect.rebind(`SELECT version FROM cqrs_checkpoints
+		WHERE projection = ? AND aggregate_name = ? AND aggregate_id = ?`),
+		t.name, e.Aggregate.Type, e.Aggregate.ID).Scan(&v)
+
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if e.Version != 0 && e.Version <= v {
+		log.Debug("cqrs.table", "%s skips %s, already applied", t.name, e)
+		return nil
+	}
+
+	row, err := h(tx, e)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", t.name, e, err)
+	}
+
+	q := t.dialect.upsert(t.dialect, "cqrs_checkpoints",
+		[]string{"projection", "aggregate_name", "aggregate_id"},
+		[]string{"projection", "aggregate_name", "aggregate_id", "version", "row_key"})
+
+	if _, err := tx.Exec(t.dialect.rebind(q), t.name, e.Aggregate.Type, e.Aggregate.ID, e.Version, row); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Create table of projection and table of checkpoints, overwrite drops table
+// and checkpoints of projection.
+func (t *TableProjection) Create(overwrite ...bool) error {
+	if t.err != nil {
+		return t.err
+	}
+
+	if _, err := t.db.Exec(createCheckpointsTable); err != nil {
+		return err
+	}
+
+	if len(overwrite) == 1 && overwrite[0] {
+		if _, err := t.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", t.dialect.ident(t.table))); err != nil {
+			return err
+		}
+
+		if _, err := t.db.Exec(t.dialect.rebind("DELETE FROM cqrs_checkpoints WHERE projection = ?"), t.name); err != nil {
+			return err
+		}
+	}
+
+	var cc []string
+	for _, c := range t.columns {
+		cc = append(cc, t.dialect.ident(c)+" "+t.types[c])
+	}
+
+	q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s,\n  PRIMARY KEY (%s)\n)",
+		t.dialect.ident(t.table), strings.Join(cc, ",\n  "), t.dialect.ident(t.key))
+
+	_, err := t.db.Exec(q)
+	return err
+}
+
+// Delete row of given key with checkpoints of aggregates which wrote it last,
+// so events of these aggregates are no longer skipped. It does not replay them,
+// row is rebuilt only when they are delivered again.
+func (t *TableProjection) Delete(id string) error {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	q := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", t.dialect.ident(t.table), t.dialect.ident(t.key))
+	if _, err := tx.Exec(t.dialect.rebind(q), id); err != nil {
+		return err
+	}
+
+	q = "DELETE FROM cqrs_checkpoints WHERE projection = ? AND row_key = ?"
+	if _, err := tx.Exec(t.dialect.rebind(q), t.name, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const createCheckpointsTable = `CREATE TABLE IF NOT EXISTS cqrs_checkpoints (
+  projection varchar(191) NOT NULL,
+  aggregate_name varchar(191) NOT NULL,
+  aggregate_id varchar(191) NOT NULL,
+  version bigint NOT NULL,
+  row_key varchar(191) NOT NULL DEFAULT '',
+  PRIMARY KEY (projection, aggregate_name, aggregate_id)
+)`
@@ -0,0 +1,180 @@
+package cqrs_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+	_ "modernc.org/sqlite"
+)
+
+type Reviewed struct {
+	Book  string
+	Stars int
+}
+
+func TestTableProjection(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every connection has own in memory database.
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	p := cqrs.NewTableProjection(db, cqrs.SQLiteDialect, "books").
+		Column("id", "varchar(64) NOT NULL").
+		Column("title", "varchar(255)").
+		Column("stars", "int").
+		OnUpsert("Book", Created{}, func(e cqrs.Event) (cqrs.Row, error) {
+			return cqrs.Row{"id": e.Aggregate.ID, "title": e.Data.(Created).Name}, nil
+		}).
+		OnUpsert("Review", Reviewed{}, func(e cqrs.Event) (cqrs.Row, error) {
+			return cqrs.Row{"id": e.Data.(Reviewed).Book, "stars": e.Data.(Reviewed).Stars}, nil
+		}).
+		OnUpsert("Author", Created{}, func(e cqrs.Event) (cqrs.Row, error) {
+			return cqrs.Row{"id": e.Data.(Created).Name, "title": e.Aggregate.ID}, nil
+		})
+
+	if err := p.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(aggregate, id string, data interface{}) cqrs.Event {
+		e := cqrs.Event{Data: data, Type: "Created", Version: 1}
+		if _, ok := data.(Reviewed); ok {
+			e.Type = "Reviewed"
+		}
+
+		e.Aggregate.Type, e.Aggregate.ID = aggregate, id
+		return e
+	}
+
+	ee := []cqrs.Event{
+		event("Book", "dune", Created{Name: "Dune"}),
+		event("Review", "r1", Reviewed{Book: "dune", Stars: 5}),
+		event("Author", "dune", Created{Name: "herbert"}),
+	}
+
+	handle := func(ee ...cqrs.Event) {
+		t.Helper()
+		for _, e := range ee {
+			if err := p.Handle(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	row := func(id string) (title string, stars int) {
+		t.Helper()
+		err := db.QueryRow(`SELECT COALESCE(title, ''), COALESCE(stars, 0) FROM books WHERE id = ?`, id).Scan(&title, &stars)
+		if err != nil && err != sql.ErrNoRows {
+			t.Fatal(err)
+		}
+
+		return title, stars
+	}
+
+	handle(ee...)
+	if title, stars := row("dune"); title != "Dune" || stars != 5 {
+		t.Fatalf("Dune with 5 stars expected, got %q %d", title, stars)
+	}
+
+	if _, err := db.Exec(`UPDATE books SET stars = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	// event delivered again is skipped.
+	handle(ee[1])
+	if _, stars := row("dune"); stars != 1 {
+		t.Fatalf("skipped review expected, got %d stars", stars)
+	}
+
+	if err := p.Delete("dune"); err != nil {
+		t.Fatal(err)
+	}
+
+	// row is rebuilt from events of aggregates producing it, author of the
+	// same ID produces other row, so its events are skipped.
+	if _, err := db.Exec(`UPDATE books SET title = 'Frank Herbert'`); err != nil {
+		t.Fatal(err)
+	}
+
+	handle(ee...)
+	if title, stars := row("dune"); title != "Dune" || stars != 5 {
+		t.Fatalf("rebuilt row expected, got %q %d", title, stars)
+	}
+
+	if title, _ := row("herbert"); title != "Frank Herbert" {
+		t.Fatalf("row of author kept expected, got %q", title)
+	}
+}
+
+func TestTableProjectionEvents(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	events := cqrs.Registry{}
+	if err := events.Register(Created{}, cqrs.QualifiedName(Created{})); err != nil {
+		t.Fatal(err)
+	}
+
+	title := func(e cqrs.Event) (cqrs.Row, error) {
+		return cqrs.Row{"id": e.Aggregate.ID, "title": e.Data.(Created).Name}, nil
+	}
+
+	cases := []struct {
+		desc       string
+		projection *cqrs.TableProjection
+		event      string
+		err        bool
+	}{
+		{"names of Registry", cqrs.NewTableProjection(db, cqrs.SQLiteDialect, "titles").Events(events).OnUpsert("Book", Created{}, title), "cqrs_test.Created", false},
+		{"names of events", cqrs.NewTableProjection(db, cqrs.SQLiteDialect, "names").OnUpsert("Book", Created{}, title), "Created", false},
+		{"not registered event", cqrs.NewTableProjection(db, cqrs.SQLiteDialect, "renames").Events(events).OnUpsert("Book", Renamed{}, nil), "", true},
+		{"conflicting events", cqrs.NewTableProjection(db, cqrs.SQLiteDialect, "conflicts").OnUpsert("Book", Created{}, title).OnDelete("Book", created{}, nil), "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			p := c.projection.Column("id", "varchar(64) NOT NULL").Column("title", "varchar(255)")
+			ss := cqrs.Subscriptions{}
+			if err := p.Subscribe(ss); (err != nil) != c.err {
+				t.Fatalf("error %v expected, got %v", c.err, err)
+			}
+
+			if err := p.Create(); (err != nil) != c.err {
+				t.Fatalf("error %v expected, got %v", c.err, err)
+			}
+
+			if c.err {
+				if err := cqrs.NewSubscriber(es.NewMemPubSub(es.MemSynchronous()), cqrs.DefaultSerializer).Subscribe(p); err == nil {
+					t.Fatal("projection rejected by Subscriber expected")
+				}
+				return
+			}
+
+			if !ss["Book"].Is(c.event) {
+				t.Fatalf("%s event subscribed expected, got %v", c.event, ss["Book"].Names())
+			}
+
+			e := cqrs.Event{Data: Created{Name: "Dune"}, Type: c.event, Version: 1}
+			e.Aggregate.Type, e.Aggregate.ID = "Book", "dune"
+			if err := p.Handle(e); err != nil {
+				t.Fatal(err)
+			}
+
+			var n string
+			if err := db.QueryRow(`SELECT title FROM ` + p.Type() + ` WHERE id = 'dune'`).Scan(&n); err != nil || n != "Dune" {
+				t.Fatalf("Dune row expected, got %q %v", n, err)
+			}
+		})
+	}
+}
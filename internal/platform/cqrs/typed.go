@@ -0,0 +1,159 @@
+package cqrs
+
+import (
+	"fmt"
+)
+
+// RegisterEvent registers event type T in Registry.
+func RegisterEvent[T any](r Registry) error {
+	var e T
+	return r.Register(e, "")
+}
+
+// AssignEvent assigns event type T to aggregate in Subscriptions.
+func AssignEvent[T any](ss Subscriptions, aggregate string) error {
+	var e T
+	return ss.Assign(aggregate, e)
+}
+
+// SubscribeEvent subscribes function handling event type T of aggregate, as
+// projection of given name.
+func SubscribeEvent[T any](s *Subscriber, projection, aggregate string, f func(T, Event) error) error {
+	return s.Subscribe(&eventProjection[T]{name: projection, aggregate: aggregate, handle: f})
+}
+
+// eventProjection is Projection of one type of event, made by SubscribeEvent.
+type eventProjection[T any] struct {
+	name      string
+	aggregate string
+	handle    func(T, Event) error
+}
+
+func (p *eventProjection[T]) Type() string { return p.name }
+
+func (p *eventProjection[T]) Subscribe(ss Subscriptions) error {
+	return AssignEvent[T](ss, p.aggregate)
+}
+
+func (p *eventProjection[T]) Handle(e Event) error {
+	v, err := EventData[T](e)
+	if err != nil {
+		return err
+	}
+
+	return p.handle(v, e)
+}
+
+// OnEvent registers in Dispatcher function handling event type T, it is
+// compile time checked variant of Dispatcher.On.
+func OnEvent[T any](d *Dispatcher, f func(T, Event) error) error {
+	var t T
+	return d.register(t, func(e Event) error {
+		v, err := EventData[T](e)
+		if err != nil {
+			return err
+		}
+
+		return f(v, e)
+	})
+}
+
+// EventData returns data of Event as type T, it accepts data given by value or
+// pointer.
+func EventData[T any](e Event) (T, error) {
+	v, ok := typed[T](e.Data)
+	if !ok {
+		return v, fmt.Errorf("%s event: %T data given, %T expected", e.Type, e.Data, v)
+	}
+
+	return v, nil
+}
+
+// AggregateRepository is Repository of one type of aggregate, created by given
+// function and loaded with events of Registry.
+type AggregateRepository[A AggregateRoot] struct {
+	repository *Repository
+	events     Registry
+	aggregate  func(id string) A
+}
+
+func NewAggregateRepository[A AggregateRoot](r *Repository, events Registry, aggregate func(id string) A) *AggregateRepository[A] {
+	return &AggregateRepository[A]{
+		repository: r,
+		events:     events,
+		aggregate:  aggregate,
+	}
+}
+
+// Load creates aggregate and applies its stored events.
+func (r *AggregateRepository[A]) Load(id string) (A, error) {
+	a := r.aggregate(id)
+	if err := r.repository.Load(a, r.events); err != nil {
+		var zero A
+		return zero, err
+	}
+
+	return a, nil
+}
+
+func (r *AggregateRepository[A]) Store(a A, m Meta) error {
+	return r.repository.Store(a, m)
+}
+
+// Executor creates Executor of aggregate.
+func (r *AggregateRepository[A]) Executor() *TypedExecutor[A] {
+	return &TypedExecutor[A]{
+		Executor: NewExecutor(r.repository, r.events, func(id string) AggregateRoot { return r.aggregate(id) }),
+	}
+}
+
+// TypedExecutor is Executor calling commands on concrete type of aggregate.
+type TypedExecutor[A AggregateRoot] struct {
+	*Executor
+}
+
+// Execute calls f on loaded aggregate and stores events it raised.
+func (x *TypedExecutor[A]) Execute(id string, m Meta, f func(A) error) Response {
+	return x.Executor.Execute(id, m, func(a AggregateRoot) error { return f(a.(A)) })
+}
+
+// CommandHandlerOf creates CommandHandler executing command type C on
+// aggregate, ready to be registered in CommandBus.
+func CommandHandlerOf[A AggregateRoot, C any](x *TypedExecutor[A], f func(a A, command C, m Meta) error) CommandHandler {
+	return CommandHandlerFunc(func(id string, c interface{}, m Meta) Response {
+		v, ok := typed[C](c)
+		if !ok {
+			return Response{ID: id, Name: name(c), Error: fmt.Errorf("%T command given, %T expected", c, v)}
+		}
+
+		return x.Execute(id, m, func(a A) error { return f(a, v, m) })
+	})
+}
+
+// RegisterCommand registers in CommandBus handler of command type C.
+func RegisterCommand[C any](b *CommandBus, f func(id string, command C, m Meta) Response) error {
+	var c C
+	return b.Register(c, CommandHandlerFunc(func(id string, v interface{}, m Meta) Response {
+		t, ok := typed[C](v)
+		if !ok {
+			return Response{ID: id, Name: name(v), Error: fmt.Errorf("%T command given, %T expected", v, c)}
+		}
+
+		return f(id, t, m)
+	}))
+}
+
+// typed converts value or pointer to T.
+func typed[T any](v interface{}) (T, bool) {
+	switch t := v.(type) {
+	case T:
+		return t, true
+	case *T:
+		if t != nil {
+			return *t, true
+		}
+	}
+
+	var t T
+	return t, false
+}
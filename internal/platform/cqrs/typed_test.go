@@ -0,0 +1,173 @@
+package cqrs_test
+
+import (
+	"testing"
+
+	"github.com/sokool/shelf2/internal/platform/cqrs"
+	"github.com/sokool/shelf2/internal/platform/cqrs/es"
+)
+
+func TestEventData(t *testing.T) {
+	cases := []struct {
+		desc string
+		data interface{}
+		ok   bool
+	}{
+		{"value", Created{Name: "Dune"}, true},
+		{"pointer", &Created{Name: "Dune"}, true},
+		{"nil pointer", (*Created)(nil), false},
+		{"other type", Renamed{Name: "Dune"}, false},
+		{"no data", nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			v, err := cqrs.EventData[Created](cqrs.Event{Type: "Created", Data: c.data})
+			if (err == nil) != c.ok {
+				t.Fatalf("converted %v expected, got %v", c.ok, err)
+			}
+
+			if c.ok && v.Name != "Dune" {
+				t.Fatalf("Dune expected, got %+v", v)
+			}
+
+			p, err := cqrs.EventData[*Created](cqrs.Event{Type: "Created", Data: c.data})
+			if c.desc == "pointer" && (err != nil || p.Name != "Dune") {
+				t.Fatalf("pointer data expected, got %+v %v", p, err)
+			}
+		})
+	}
+}
+
+func TestEventRegistration(t *testing.T) {
+	r := cqrs.Registry{}
+	if err := cqrs.RegisterEvent[Created](r); err != nil || !r.Is("Created") {
+		t.Fatalf("Created registered expected, got %v %v", r.Names(), err)
+	}
+
+	ss := cqrs.Subscriptions{}
+	for _, a := range []func(cqrs.Subscriptions, string) error{cqrs.AssignEvent[Created], cqrs.AssignEvent[*Renamed]} {
+		if err := a(ss, "Book"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := ss["Book"].Names(); len(n) != 2 || n[0] != "Created" || n[1] != "Renamed" {
+		t.Fatalf("Created and Renamed assigned expected, got %v", n)
+	}
+
+	d, err := cqrs.NewDispatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	if err := cqrs.OnEvent(d, func(e Created, m cqrs.Event) error { names = append(names, m.Type+":"+e.Name); return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []interface{}{Created{Name: "Dune"}, &Created{Name: "Emma"}} {
+		if err := d.Handle(cqrs.Event{Type: "Created", Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.Handle(cqrs.Event{Type: "Created", Data: Renamed{}}); err == nil {
+		t.Fatal("data of other type rejected expected")
+	}
+
+	if len(names) != 2 || names[0] != "Created:Dune" || names[1] != "Created:Emma" {
+		t.Fatalf("events given by value and pointer handled expected, got %v", names)
+	}
+}
+
+func TestAggregateRepository(t *testing.T) {
+	events := cqrs.Registry{}.New(Created{}, Renamed{})
+	r := cqrs.NewAggregateRepository(cqrs.NewRepository(es.NewMemory(), nil, cqrs.DefaultSerializer), events, newBook)
+
+	x := r.Executor()
+	if res := x.Execute("dune", nil, func(b *book) error { return b.Raise(Created{Name: "Dune"}) }); res.Error != nil || res.Version != 1 {
+		t.Fatalf("book created in version 1 expected, got %+v", res)
+	}
+
+	rename := cqrs.CommandHandlerOf(x, func(b *book, c Rename, m cqrs.Meta) error { return b.Raise(Renamed{Name: c.Name}) })
+	cases := []struct {
+		desc    string
+		command interface{}
+		ok      bool
+	}{
+		{"value", Rename{Name: "Dune Messiah"}, true},
+		{"pointer", &Rename{Name: "Children of Dune"}, true},
+		{"other command", FindBook{}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			if res := rename.Handle("dune", c.command, nil); (res.Error == nil) != c.ok {
+				t.Fatalf("handled %v expected, got %+v", c.ok, res)
+			}
+		})
+	}
+
+	l, err := r.Load("dune")
+	if err != nil || l.title != "Children of Dune" {
+		t.Fatalf("renamed book expected, got %q %v", l.title, err)
+	}
+
+	if err := l.Raise(Renamed{Name: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Store(l, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err = r.Load("dune"); err != nil || l.title != "Dune" {
+		t.Fatalf("stored rename expected, got %q %v", l.title, err)
+	}
+
+	var ids []string
+	b := cqrs.NewCommandBus()
+	err = cqrs.RegisterCommand(b, func(id string, c FindBook, m cqrs.Meta) cqrs.Response {
+		ids = append(ids, id)
+		return cqrs.Response{ID: id}
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := b.Handle("emma", &FindBook{}, nil); res.Error != nil || len(ids) != 1 || ids[0] != "emma" {
+		t.Fatalf("typed command handled expected, got %+v %v", res, ids)
+	}
+}
+
+func TestSubscribeEvent(t *testing.T) {
+	p := es.NewMemPubSub(es.MemSynchronous())
+	s := cqrs.NewSubscriber(p, cqrs.DefaultSerializer)
+
+	var titles []string
+	err := cqrs.SubscribeEvent(s, "titles", "Book", func(e Created, m cqrs.Event) error {
+		titles = append(titles, m.Aggregate.ID+":"+e.Name)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{"Created", "Renamed"} {
+		a := es.AggregateEvents{
+			Aggregate: es.Aggregate{ID: "dune", Type: "Book"},
+			Events:    []es.Event{{Type: e, Version: 1, Data: []byte(`{"Name":"Dune"}`), Meta: []byte(`{}`)}},
+		}
+
+		if err := p.Publish(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(titles) != 1 || titles[0] != "dune:Dune" {
+		t.Fatalf("created book handled expected, got %v", titles)
+	}
+}